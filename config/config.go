@@ -11,6 +11,21 @@ import (
 
 const (
 	CONFIGFILENAME string = "config.json"
+
+	// IpfsStore uploads the files to the ipfs node at NodeAddr.
+	IpfsStore string = "ipfs"
+
+	// LocalStore saves the files in the config dir without an ipfs node.
+	LocalStore string = "local"
+)
+
+var (
+	// DefaultRetention is the retention used when none is configured.
+	DefaultRetention = Retention{
+		KeepLast:        10,
+		KeepDailyDays:   30,
+		KeepDeletedDays: 30,
+	}
 )
 
 var (
@@ -31,10 +46,53 @@ type Config struct {
 
 	// Port to use by p2p connections.
 	P2PPort string `json:"p2p_port"`
+
+	// ContentStore is the store the files are uploaded to: ipfs or local. (Default: ipfs)
+	ContentStore string `json:"content_store"`
+
+	// Retention is the snapshot retention settings. (Default: DefaultRetention)
+	Retention Retention `json:"retention"`
+
+	// Ignore holds the global gitignore patterns, evaluated before the .orbitignore files.
+	Ignore []string `json:"ignore"`
+
+	// QuietWindowMs is the amount of milliseconds without events on a path
+	// before the watcher handles them. (Default: 500)
+	QuietWindowMs int `json:"quiet_window_ms"`
+
+	// WatcherBackend is the watcher backend: notify or poll. (Default: notify)
+	WatcherBackend string `json:"watcher_backend"`
+
+	// PollIntervalMs is the amount of milliseconds between two scans of the
+	// poll backend. (Default: 2000)
+	PollIntervalMs int `json:"poll_interval_ms"`
+
+	// Symlinks is the symbolic links policy: preserve, follow or skip. (Default: preserve)
+	Symlinks string `json:"symlinks"`
+
+	// ConflictPolicy is the resolution of the paths changed locally and by a
+	// peer: keep_both, newest or local. (Default: keep_both)
+	ConflictPolicy string `json:"conflict_policy"`
+
+	// DeviceName names the device in the conflict copies written on the peers. (Default: hostname)
+	DeviceName string `json:"device_name"`
+}
+
+// Retention represents the snapshot retention settings, old snapshots
+// and the contents only they reference are garbage collected.
+type Retention struct {
+	// KeepLast is the amount of latest snapshots to keep.
+	KeepLast int `json:"keep_last"`
+
+	// KeepDailyDays is the amount of days to keep the last snapshot of each day.
+	KeepDailyDays int `json:"keep_daily_days"`
+
+	// KeepDeletedDays is the amount of days to keep deleted files.
+	KeepDeletedDays int `json:"keep_deleted_days"`
 }
 
 // NewConfig initialize a new usr config and save it to config file.
-func NewConfig(root, secretPhrase, nodeAddr, p2pPort string) error {
+func NewConfig(root, secretPhrase, nodeAddr, p2pPort, contentStore string) error {
 	if secretPhrase == "" {
 		return ErrSecretPhraseNotProvided
 	}
@@ -54,6 +112,8 @@ func NewConfig(root, secretPhrase, nodeAddr, p2pPort string) error {
 		SecretPhrase: string(spHash),
 		NodeAddr:     nodeAddr,
 		P2PPort:      p2pPort,
+		ContentStore: contentStore,
+		Retention:    DefaultRetention,
 	}
 
 	configData, err := json.MarshalIndent(config, "", "  ")
@@ -83,6 +143,12 @@ func LoadConfig(nodeAddr, p2pPort string) (*Config, error) {
 	if p2pPort != "" {
 		config.P2PPort = p2pPort
 	}
+	if config.Retention == (Retention{}) {
+		config.Retention = DefaultRetention
+	}
+	if config.DeviceName == "" {
+		config.DeviceName, _ = os.Hostname()
+	}
 	return config, nil
 }
 
@@ -1,7 +1,9 @@
 package db
 
 import (
+	"errors"
 	"os"
+	"syscall"
 
 	"github.com/orbit-drive/orbit-drive/utils"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -12,7 +14,14 @@ var (
 	Db *leveldb.DB
 )
 
-// InitDb initialize the global Db instance located at (HOME_PATH/.orbit-drive/datastore)
+var (
+	// ErrDbLocked is returned when the datastore is opened by another process.
+	ErrDbLocked = errors.New("db: datastore is in use by another orbit-drive process, stop orbit-drive sync first")
+)
+
+// InitDb initialize the global Db instance located at (HOME_PATH/.orbit-drive/datastore),
+// returns ErrDbLocked if another process holds the datastore lock. The lock is
+// held until CloseDb, opening read only would still conflict with it.
 func InitDb() error {
 	cp := utils.GetConfigDir() + "/datastore"
 
@@ -22,6 +31,9 @@ func InitDb() error {
 
 	var err error
 	Db, err = leveldb.OpenFile(cp, nil)
+	if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
+		return ErrDbLocked
+	}
 	return err
 }
 
@@ -35,6 +47,15 @@ func Get(k []byte) ([]byte, error) {
 	return Db.Get(k, nil)
 }
 
+// BatchDelete deletes all the given keys in a single batch write.
+func BatchDelete(keys [][]byte) error {
+	b := new(leveldb.Batch)
+	for _, k := range keys {
+		b.Delete(k)
+	}
+	return Db.Write(b, nil)
+}
+
 // CloseDb is a wrapper to leveldb Close func
 func CloseDb() {
 	Db.Close()
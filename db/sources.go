@@ -3,6 +3,7 @@ package db
 import (
 	"encoding/json"
 	"os"
+	"strings"
 
 	"github.com/orbit-drive/orbit-drive/utils"
 	log "github.com/sirupsen/logrus"
@@ -19,6 +20,12 @@ type Source struct {
 
 	// Checksum represents the md5 checksum hash of file.
 	Checksum string `json:"checksum"`
+
+	// Mode represents the permission bits of the file.
+	Mode uint32 `json:"mode"`
+
+	// ModTime represents the modification time of the file in unix nanoseconds.
+	ModTime int64 `json:"mod_time"`
 }
 
 // Sources represents the store of the locally saved files.
@@ -40,6 +47,8 @@ func NewSource(path string) *Source {
 		Src:      "",
 		Size:     fi.Size(),
 		Checksum: checksum,
+		Mode:     uint32(fi.Mode().Perm()),
+		ModTime:  fi.ModTime().UnixNano(),
 	}
 }
 
@@ -59,6 +68,8 @@ func (s *Source) DeepCopy() *Source {
 		Src:      s.GetSrc(),
 		Size:     s.Size,
 		Checksum: s.Checksum,
+		Mode:     s.Mode,
+		ModTime:  s.ModTime,
 	}
 }
 
@@ -81,23 +92,34 @@ func (s *Source) IsSame(c *Source) bool {
 	return s.Size == c.Size && s.Checksum == c.Checksum
 }
 
-// GetSources iterates through db, populate and return Sources.
-func GetSources() (Sources, error) {
+// IsSameMeta check if the 2 sources have the same mode and modification time.
+func (s *Source) IsSameMeta(c *Source) bool {
+	return s.Mode == c.Mode && s.ModTime == c.ModTime
+}
+
+// IsExecutable returns true if any of the executable bits is set.
+func (s *Source) IsExecutable() bool {
+	return s.Mode&0111 != 0
+}
+
+// GetSources iterates through db, populate and return Sources. The keys
+// starting with one of the reserved prefixes do not hold sources and are
+// skipped.
+func GetSources(reserved ...string) (Sources, error) {
 	store := make(Sources)
 	iter := Db.NewIterator(nil, nil)
 	for iter.Next() {
 		k := utils.ToStr(iter.Key())
-		switch k {
-		case "ROOT_TREE":
-		default:
-			s := &Source{}
-			err := json.Unmarshal(iter.Value(), s)
-			if err != nil {
-				log.Warn(err)
-				continue
-			}
-			store[k] = s
+		if hasPrefix(k, reserved) {
+			continue
 		}
+		s := &Source{}
+		err := json.Unmarshal(iter.Value(), s)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		store[k] = s
 	}
 	iter.Release()
 	if err := iter.Error(); err != nil {
@@ -106,6 +128,16 @@ func GetSources() (Sources, error) {
 	return store, nil
 }
 
+// hasPrefix returns true if k starts with one of the prefixes.
+func hasPrefix(k string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // ExtractSource look for and return a copy of Source and
 // deletes the key from the mapping.
 func (s Sources) ExtractSource(k string) *Source {
@@ -139,3 +171,21 @@ func (s Sources) Save() error {
 	}
 	return Db.Write(b, nil)
 }
+
+// Replace batch deletes the given keys and put all the entries in the
+// mapping in a single write.
+func (s Sources) Replace(keys [][]byte) error {
+	b := new(leveldb.Batch)
+	for _, k := range keys {
+		b.Delete(k)
+	}
+	for k, source := range s {
+		data, err := json.Marshal(source)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		b.Put(utils.ToByte(k), data)
+	}
+	return Db.Write(b, nil)
+}
@@ -0,0 +1,235 @@
+package ignore
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// IGNOREFILENAME is the name of the files holding the ignore patterns of a dir.
+	IGNOREFILENAME = ".orbitignore"
+)
+
+var (
+	// DefaultPatterns are the patterns evaluated before any other pattern,
+	// hidden files are ignored unless negated by a later pattern.
+	DefaultPatterns = []string{".*"}
+)
+
+// pattern represents a single gitignore pattern.
+type pattern struct {
+	// negate re-includes the paths matched.
+	negate bool
+
+	// dirOnly only matches dirs.
+	dirOnly bool
+
+	re *regexp.Regexp
+}
+
+// match returns true if the slash separated path relative to the
+// pattern dir matches the pattern.
+func (p *pattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return p.re.MatchString(rel)
+}
+
+// Matcher evaluates the ignore patterns of a root path with the gitignore
+// semantics: the global patterns first, then the .orbitignore files from
+// the root down to the path dir, the last matching pattern wins.
+type Matcher struct {
+	sync.Mutex
+
+	// Root is the absolute path the patterns are relative to.
+	Root string
+
+	global []*pattern
+
+	// dirs caches the parsed .orbitignore patterns by dir.
+	dirs map[string][]*pattern
+}
+
+// NewMatcher initialize a Matcher for the root path with the default
+// patterns followed by the given global patterns.
+func NewMatcher(root string, patterns []string) *Matcher {
+	m := &Matcher{
+		Root: root,
+		dirs: make(map[string][]*pattern),
+	}
+	m.SetPatterns(patterns)
+	return m
+}
+
+// SetPatterns replaces the global patterns by the default patterns
+// followed by the given patterns.
+func (m *Matcher) SetPatterns(patterns []string) {
+	m.Lock()
+	defer m.Unlock()
+	m.global = parsePatterns(append(append([]string{}, DefaultPatterns...), patterns...))
+}
+
+// Reload drops the cached .orbitignore patterns of dir, they are
+// read again on the next match.
+func (m *Matcher) Reload(dir string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.dirs, dir)
+}
+
+// Ignored returns true if the absolute path or one of its parent dirs is
+// ignored. Paths outside of the root are never ignored.
+func (m *Matcher) Ignored(path string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	rel, err := filepath.Rel(m.Root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	// A path can not be re-included if one of its parent dirs is ignored.
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		if m.match(parts[:i+1], isDir || i < len(parts)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// match evaluates all the patterns against the path split by dir from the root.
+func (m *Matcher) match(parts []string, isDir bool) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	ignored := false
+	rel := strings.Join(parts, "/")
+	for _, p := range m.global {
+		if p.match(rel, isDir) {
+			ignored = !p.negate
+		}
+	}
+
+	dir := m.Root
+	for i := range parts {
+		rel := strings.Join(parts[i:], "/")
+		for _, p := range m.patterns(dir) {
+			if p.match(rel, isDir) {
+				ignored = !p.negate
+			}
+		}
+		dir = filepath.Join(dir, parts[i])
+	}
+	return ignored
+}
+
+// patterns returns the patterns of the dir .orbitignore file.
+func (m *Matcher) patterns(dir string) []*pattern {
+	if patterns, ok := m.dirs[dir]; ok {
+		return patterns
+	}
+	lines := []string{}
+	if f, err := os.Open(filepath.Join(dir, IGNOREFILENAME)); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		f.Close()
+	}
+	m.dirs[dir] = parsePatterns(lines)
+	return m.dirs[dir]
+}
+
+func parsePatterns(lines []string) []*pattern {
+	patterns := []*pattern{}
+	for _, line := range lines {
+		if p := parsePattern(line); p != nil {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// parsePattern parses a gitignore line, returns nil for blank lines,
+// comments and invalid patterns.
+func parsePattern(line string) *pattern {
+	line = strings.TrimRight(line, " \t")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	p := &pattern{}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+
+	// A pattern with a separator is relative to its dir, otherwise
+	// it matches a name at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	expr := "^"
+	if !anchored {
+		expr += "(?:.*/)?"
+	}
+	re, err := regexp.Compile(expr + globToRegexp(line) + "$")
+	if err != nil {
+		return nil
+	}
+	p.re = re
+	return p
+}
+
+// globToRegexp translates a gitignore glob to a regular expression:
+// * and ? do not match a separator and ** matches any amount of dirs.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		atSegment := i == 0 || glob[i-1] == '/'
+		switch c := glob[i]; {
+		case atSegment && strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case atSegment && glob[i:] == "**":
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
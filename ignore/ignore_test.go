@@ -0,0 +1,70 @@
+package ignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	root, err := ioutil.TempDir("", "orbit-drive-ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "src", "lib"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(root, IGNOREFILENAME), []byte(`
+# build outputs
+node_modules/
+/build
+*.swp
+!keep.swp
+docs/**/*.pdf
+!.config
+`), 0644)
+	ioutil.WriteFile(filepath.Join(root, "src", IGNOREFILENAME), []byte("*.log\n!lib/\nlib/*.tmp\n"), 0644)
+
+	m := NewMatcher(root, []string{"*.bak"})
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"node_modules", true, true},
+		{"node_modules", false, false},
+		{"src/node_modules/pkg/index.js", false, true},
+		{"build", true, true},
+		{"src/build", true, false},
+		{"notes.swp", false, true},
+		{"src/keep.swp", false, false},
+		{"docs/a.pdf", false, true},
+		{"docs/a/b/c.pdf", false, true},
+		{"a.pdf", false, false},
+		{".git", true, true},
+		{".git/config", false, true},
+		{"..foo", false, true},
+		{".config", true, false},
+		{"src/app.log", false, true},
+		{"app.log", false, false},
+		{"src/lib/x.tmp", false, true},
+		{"src/x.tmp", false, false},
+		{"old.bak", false, true},
+		{"readme.md", false, false},
+	}
+	for _, test := range tests {
+		p := filepath.Join(root, test.path)
+		if ignored := m.Ignored(p, test.isDir); ignored != test.ignored {
+			t.Errorf("Expected %s ignored to be %t, got: %t", test.path, test.ignored, ignored)
+		}
+	}
+	if m.Ignored(root, true) || m.Ignored(filepath.Dir(root), true) {
+		t.Error("Expected root and outside paths not to be ignored")
+	}
+
+	ioutil.WriteFile(filepath.Join(root, IGNOREFILENAME), []byte("readme.md\n"), 0644)
+	m.Reload(root)
+	if !m.Ignored(filepath.Join(root, "readme.md"), false) || m.Ignored(filepath.Join(root, "notes.txt"), false) {
+		t.Error("Expected reloaded patterns to be applied")
+	}
+}
@@ -0,0 +1,130 @@
+package ipfs
+
+import (
+	"encoding/binary"
+	"io"
+
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	// chunkSize is the ipfs default fixed chunker size.
+	chunkSize = 262144
+
+	// maxLinks is the ipfs default max links per dag node.
+	maxLinks = 174
+
+	// unixfsFile is the unixfs data type of a file node.
+	unixfsFile = 2
+)
+
+// dagNode holds the meta data of a dag node needed to link it from its parent.
+type dagNode struct {
+	hash     []byte
+	fileSize uint64
+	cumSize  uint64
+}
+
+// ComputeCID returns the cid generated by ipfs when adding the content
+// with the default options: cid v0, fixed size chunker and balanced layout.
+func ComputeCID(r io.Reader) (string, error) {
+	leaves := []*dagNode{}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF && len(leaves) > 0 {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+		leaves = append(leaves, newLeafNode(buf[:n]))
+		if n < chunkSize {
+			break
+		}
+	}
+
+	// Balanced layout: the minimal depth where all the leaves fit.
+	depth, capacity := 0, 1
+	for capacity < len(leaves) {
+		depth++
+		capacity *= maxLinks
+	}
+	root := buildDag(leaves, depth, capacity/maxLinks)
+	return mh.Multihash(root.hash).B58String(), nil
+}
+
+// buildDag links the leaves under nodes of the given depth, each child
+// holding up to childCapacity leaves.
+func buildDag(leaves []*dagNode, depth, childCapacity int) *dagNode {
+	if depth == 0 {
+		return leaves[0]
+	}
+	children := []*dagNode{}
+	for i := 0; i < len(leaves); i += childCapacity {
+		end := i + childCapacity
+		if end > len(leaves) {
+			end = len(leaves)
+		}
+		children = append(children, buildDag(leaves[i:end], depth-1, childCapacity/maxLinks))
+	}
+	return newParentNode(children)
+}
+
+func newLeafNode(data []byte) *dagNode {
+	unixfs := appendVarintField(nil, 1, unixfsFile)
+	if len(data) > 0 {
+		unixfs = appendBytesField(unixfs, 2, data)
+	}
+	unixfs = appendVarintField(unixfs, 3, uint64(len(data)))
+	return newDagNode(nil, unixfs, uint64(len(data)), 0)
+}
+
+func newParentNode(children []*dagNode) *dagNode {
+	var fileSize, linksSize uint64
+	var links []byte
+	for _, child := range children {
+		fileSize += child.fileSize
+		linksSize += child.cumSize
+
+		link := appendBytesField(nil, 1, child.hash)
+		link = appendBytesField(link, 2, nil)
+		link = appendVarintField(link, 3, child.cumSize)
+		links = appendBytesField(links, 2, link)
+	}
+
+	unixfs := appendVarintField(nil, 1, unixfsFile)
+	unixfs = appendVarintField(unixfs, 3, fileSize)
+	for _, child := range children {
+		unixfs = appendVarintField(unixfs, 4, child.fileSize)
+	}
+	return newDagNode(links, unixfs, fileSize, linksSize)
+}
+
+// newDagNode encodes a dag-pb node, links are encoded before the data.
+func newDagNode(links, unixfs []byte, fileSize, linksSize uint64) *dagNode {
+	block := append(links, appendBytesField(nil, 1, unixfs)...)
+	hash, _ := mh.Sum(block, mh.SHA2_256, -1)
+	return &dagNode{
+		hash:     hash,
+		fileSize: fileSize,
+		cumSize:  uint64(len(block)) + linksSize,
+	}
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field<<3))
+	return appendVarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = appendVarint(b, uint64(field<<3|2))
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	return append(b, buf[:n]...)
+}
@@ -2,10 +2,10 @@ package ipfs
 
 import (
 	"errors"
-	"os"
+	"io"
+	"strings"
 
 	shell "github.com/ipfs/go-ipfs-api"
-	"github.com/orbit-drive/orbit-drive/sys"
 )
 
 var (
@@ -18,47 +18,104 @@ var (
 	ErrNodeNotInitialized = errors.New("ipfs: node not initialized")
 )
 
-var (
+// ShellStore is a ContentStore backed by an ipfs node http api.
+type ShellStore struct {
 	// Shell holds a ipfs shell instance for access to the
 	// the ipfs network. (Default: Infura node)
 	Shell *shell.Shell
-)
+}
 
-// InitShell initialize a new shell.
-func InitShell(addr string) {
-	Shell = shell.NewShell(addr)
+// NewShellStore initialize a new shell store given the ipfs node address.
+func NewShellStore(addr string) *ShellStore {
+	return &ShellStore{
+		Shell: shell.NewShell(addr),
+	}
 }
 
 // IsLive return true if ipfs node is live.
-func IsLive() (bool, error) {
-	if Shell == nil {
+func (ss *ShellStore) IsLive() (bool, error) {
+	if ss.Shell == nil {
 		return false, ErrNodeNotInitialized
 	}
-	return Shell.IsUp(), nil
+	return ss.Shell.IsUp(), nil
 }
 
-// UploadFile takes a file path and upload it to ipfs
-// and return the generate hash.
-func UploadFile(p string) (string, error) {
-	isLive, err := IsLive()
+// Put adds the content to the ipfs node and return the generated hash.
+func (ss *ShellStore) Put(r io.Reader) (string, error) {
+	isLive, err := ss.IsLive()
 	if err != nil {
 		return "", err
 	}
 	if !isLive {
 		return "", ErrNodeOffline
 	}
-	file, err := os.Open(p)
+	return ss.Shell.Add(r)
+}
+
+// Get returns the content of the cid from the ipfs node.
+func (ss *ShellStore) Get(cid string) (io.ReadCloser, error) {
+	r, err := ss.Shell.Cat(cid)
 	if err != nil {
-		return "", err
+		return nil, notFound(err)
+	}
+	return r, nil
+}
+
+// Has returns true if the ipfs node can resolve the cid block, the errors
+// other than a missing block are returned.
+func (ss *ShellStore) Has(cid string) (bool, error) {
+	_, _, err := ss.Shell.BlockStat(cid)
+	switch err = notFound(err); err {
+	case nil:
+		return true, nil
+	case ErrContentNotFound:
+		return false, nil
 	}
-	defer file.Close()
+	return false, err
+}
 
-	sys.Notify("Uploading: ", file.Name())
-	cid, err := Shell.Add(file)
+// Pin pins the cid on the ipfs node.
+func (ss *ShellStore) Pin(cid string) error {
+	return ss.Shell.Pin(cid)
+}
+
+// Unpin removes the pin of the cid on the ipfs node.
+func (ss *ShellStore) Unpin(cid string) error {
+	return ss.Shell.Unpin(cid)
+}
+
+// Pins returns the cids recursively pinned on the ipfs node, the indirect
+// pins are the blocks of those cids and can not be unpinned on their own.
+func (ss *ShellStore) Pins() ([]string, error) {
+	pins, err := ss.Shell.Pins()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	cids := []string{}
+	for cid, info := range pins {
+		if info.Type == shell.RecursivePin {
+			cids = append(cids, cid)
+		}
 	}
+	return cids, nil
+}
 
-	sys.Notify("Uploaded: ", cid)
-	return cid, nil
+// Stat returns the file size of the cid content.
+func (ss *ShellStore) Stat(cid string) (int64, error) {
+	obj, err := ss.Shell.FileList(cid)
+	if err != nil {
+		return 0, notFound(err)
+	}
+	return int64(obj.Size), nil
+}
+
+// notFound returns ErrContentNotFound for the errors of the ipfs node
+// reporting a missing cid, the other errors such as an unreachable node
+// are returned as is.
+func notFound(err error) error {
+	e, ok := err.(*shell.Error)
+	if ok && e.Message != "command not found" && strings.Contains(e.Message, "not found") {
+		return ErrContentNotFound
+	}
+	return err
 }
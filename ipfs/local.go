@@ -0,0 +1,116 @@
+package ipfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore is a ContentStore saving the contents as files in a local dir
+// and generating the same cids as ipfs. Unpinned content is removed right
+// away as there is no garbage collection.
+type LocalStore struct {
+	// Dir is the absolute path of the dir holding the contents.
+	Dir string
+}
+
+// NewLocalStore initialize a new LocalStore and creates its dir.
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &LocalStore{Dir: dir}, nil
+}
+
+// Put copies the content to a temp file while computing its cid and
+// renames the file to the cid.
+func (ls *LocalStore) Put(r io.Reader) (string, error) {
+	tmp, err := ioutil.TempFile(ls.Dir, ".put-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	cid, err := ComputeCID(io.TeeReader(r, tmp))
+	if err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return cid, os.Rename(tmp.Name(), ls.contentPath(cid))
+}
+
+// Get returns a reader to the content of the cid.
+func (ls *LocalStore) Get(cid string) (io.ReadCloser, error) {
+	f, err := os.Open(ls.contentPath(cid))
+	if os.IsNotExist(err) {
+		return nil, ErrContentNotFound
+	}
+	return f, err
+}
+
+// Has returns true if the content of the cid is in the dir.
+func (ls *LocalStore) Has(cid string) (bool, error) {
+	_, err := os.Stat(ls.contentPath(cid))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Pin returns ErrContentNotFound if the content of the cid is not in the dir,
+// content is always pinned once saved.
+func (ls *LocalStore) Pin(cid string) error {
+	if ok, err := ls.Has(cid); !ok {
+		if err != nil {
+			return err
+		}
+		return ErrContentNotFound
+	}
+	return nil
+}
+
+// Unpin removes the content of the cid from the dir.
+func (ls *LocalStore) Unpin(cid string) error {
+	err := os.Remove(ls.contentPath(cid))
+	if os.IsNotExist(err) {
+		return ErrContentNotFound
+	}
+	return err
+}
+
+// Stat returns the size of the content of the cid.
+func (ls *LocalStore) Stat(cid string) (int64, error) {
+	fi, err := os.Stat(ls.contentPath(cid))
+	if os.IsNotExist(err) {
+		return 0, ErrContentNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Pins returns the cids of all the contents in the dir, the temp files of
+// the contents being saved are skipped.
+func (ls *LocalStore) Pins() ([]string, error) {
+	files, err := ioutil.ReadDir(ls.Dir)
+	if err != nil {
+		return nil, err
+	}
+	cids := []string{}
+	for _, f := range files {
+		if !f.IsDir() && !strings.HasPrefix(f.Name(), ".") {
+			cids = append(cids, f.Name())
+		}
+	}
+	return cids, nil
+}
+
+func (ls *LocalStore) contentPath(cid string) string {
+	return filepath.Join(ls.Dir, filepath.Base(cid))
+}
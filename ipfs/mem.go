@@ -0,0 +1,106 @@
+package ipfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// MemStore is an in memory ContentStore generating the same cids as ipfs.
+// Unpinned content is removed right away as there is no garbage collection.
+type MemStore struct {
+	sync.RWMutex
+
+	contents map[string][]byte
+}
+
+// NewMemStore initialize a new empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{
+		contents: make(map[string][]byte),
+	}
+}
+
+// Put saves the content in memory and returns its cid.
+func (ms *MemStore) Put(r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	cid, err := ComputeCID(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+	ms.contents[cid] = data
+	return cid, nil
+}
+
+// Get returns a reader to the content of the cid.
+func (ms *MemStore) Get(cid string) (io.ReadCloser, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	data, ok := ms.contents[cid]
+	if !ok {
+		return nil, ErrContentNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Has returns true if the content of the cid is in memory.
+func (ms *MemStore) Has(cid string) (bool, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	_, ok := ms.contents[cid]
+	return ok, nil
+}
+
+// Pin returns ErrContentNotFound if the content of the cid is not in memory,
+// content is always pinned once saved.
+func (ms *MemStore) Pin(cid string) error {
+	if ok, _ := ms.Has(cid); !ok {
+		return ErrContentNotFound
+	}
+	return nil
+}
+
+// Unpin removes the content of the cid from memory.
+func (ms *MemStore) Unpin(cid string) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	if _, ok := ms.contents[cid]; !ok {
+		return ErrContentNotFound
+	}
+	delete(ms.contents, cid)
+	return nil
+}
+
+// Stat returns the size of the content of the cid.
+func (ms *MemStore) Stat(cid string) (int64, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	data, ok := ms.contents[cid]
+	if !ok {
+		return 0, ErrContentNotFound
+	}
+	return int64(len(data)), nil
+}
+
+// Pins returns the cids of all the contents in memory.
+func (ms *MemStore) Pins() ([]string, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	cids := make([]string, 0, len(ms.contents))
+	for cid := range ms.contents {
+		cids = append(cids, cid)
+	}
+	return cids, nil
+}
@@ -0,0 +1,58 @@
+package ipfs
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/orbit-drive/orbit-drive/sys"
+)
+
+var (
+	// ErrContentNotFound is returned when accessing a cid missing from the store.
+	ErrContentNotFound = errors.New("ipfs: content not found")
+)
+
+// ContentStore represents a content addressed store where the files
+// are uploaded to, each content is referred by its ipfs cid.
+type ContentStore interface {
+	// Put saves and pins the content and returns its cid.
+	Put(r io.Reader) (string, error)
+
+	// Get returns a reader to the content of the cid.
+	Get(cid string) (io.ReadCloser, error)
+
+	// Has returns true if the content of the cid is in the store.
+	Has(cid string) (bool, error)
+
+	// Pin prevents the content of the cid from being garbage collected.
+	Pin(cid string) error
+
+	// Unpin allows the content of the cid to be garbage collected.
+	Unpin(cid string) error
+
+	// Stat returns the size of the content of the cid.
+	Stat(cid string) (int64, error)
+
+	// Pins returns the cids of all the contents pinned in the store.
+	Pins() ([]string, error)
+}
+
+// UploadFile takes a file path and upload it to the content store
+// and return the generated hash.
+func UploadFile(cs ContentStore, p string) (string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sys.Notify("Uploading: ", file.Name())
+	cid, err := cs.Put(file)
+	if err != nil {
+		return "", err
+	}
+
+	sys.Notify("Uploaded: ", cid)
+	return cid, nil
+}
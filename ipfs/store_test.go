@@ -0,0 +1,130 @@
+package ipfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestComputeCID(t *testing.T) {
+	random := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1 << 20)).Read(random)
+
+	tests := []struct {
+		name    string
+		content []byte
+		cid     string
+	}{
+		{"empty", []byte{}, "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"},
+		{"single chunk", []byte("hello world\n"), "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"},
+		{"multiple chunks", random, "QmP9RgiaLVY7iL9XoPsAgTtPJtD2UQDoCFqGhByzn43Ua4"},
+	}
+	for _, test := range tests {
+		cid, err := ComputeCID(bytes.NewReader(test.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cid != test.cid {
+			t.Errorf("%s: expected cid %s, got: %s", test.name, test.cid, cid)
+		}
+	}
+}
+
+func testContentStore(t *testing.T, cs ContentStore) {
+	content := "hello world\n"
+	cid, err := cs.Put(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid != "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o" {
+		t.Errorf("Expected ipfs cid, got: %s", cid)
+	}
+
+	if ok, _ := cs.Has(cid); !ok {
+		t.Error("Expected content to be in store.")
+	}
+	if size, _ := cs.Stat(cid); size != int64(len(content)) {
+		t.Errorf("Expected size %d, got: %d", len(content), size)
+	}
+	r, err := cs.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(data) != content {
+		t.Errorf("Expected content %q, got: %q", content, data)
+	}
+
+	if err := cs.Pin(cid); err != nil {
+		t.Error(err)
+	}
+	if pins, _ := cs.Pins(); len(pins) != 1 || pins[0] != cid {
+		t.Errorf("Expected pins [%s], got: %v", cid, pins)
+	}
+	if err := cs.Unpin(cid); err != nil {
+		t.Error(err)
+	}
+	if pins, _ := cs.Pins(); len(pins) != 0 {
+		t.Errorf("Expected no pins, got: %v", pins)
+	}
+	if ok, _ := cs.Has(cid); ok {
+		t.Error("Expected unpinned content to be removed from store.")
+	}
+	if _, err := cs.Get(cid); err != ErrContentNotFound {
+		t.Errorf("Expected %v, got: %v", ErrContentNotFound, err)
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	testContentStore(t, NewMemStore())
+}
+
+func TestLocalStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orbit-drive-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ls, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testContentStore(t, ls)
+}
+
+func TestShellStoreErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"Message": "blockservice: key not found", "Code": 0, "Type": "error"}`))
+	}))
+	ss := NewShellStore(strings.TrimPrefix(srv.URL, "http://"))
+	cid := "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+
+	// Missing content is reported as such.
+	if ok, err := ss.Has(cid); ok || err != nil {
+		t.Errorf("Expected missing content, got: %v %v", ok, err)
+	}
+	if _, err := ss.Get(cid); err != ErrContentNotFound {
+		t.Errorf("Expected %v, got: %v", ErrContentNotFound, err)
+	}
+	if _, err := ss.Stat(cid); err != ErrContentNotFound {
+		t.Errorf("Expected %v, got: %v", ErrContentNotFound, err)
+	}
+
+	// An unreachable node is an error, not missing content.
+	srv.Close()
+	if _, err := ss.Has(cid); err == nil || err == ErrContentNotFound {
+		t.Errorf("Expected a transport error, got: %v", err)
+	}
+	if _, err := ss.Get(cid); err == nil || err == ErrContentNotFound {
+		t.Errorf("Expected a transport error, got: %v", err)
+	}
+}
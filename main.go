@@ -11,6 +11,7 @@ import (
 	"github.com/orbit-drive/orbit-drive/db"
 	"github.com/orbit-drive/orbit-drive/sync"
 	"github.com/orbit-drive/orbit-drive/utils"
+	"github.com/orbit-drive/orbit-drive/vtree"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -49,10 +50,60 @@ func main() {
 		Default:  "",
 		Help:     "Set a secret phrase and share with our devices you with to sync with.",
 	})
+	contentStore := initCmd.Selector("c", "content-store", []string{config.IpfsStore, config.LocalStore}, &argparse.Options{
+		Required: false,
+		Default:  config.IpfsStore,
+		Help:     "Store to upload files to, local store does not require an ipfs node.",
+	})
 
 	// sync command
 	syncCmd := p.NewCommand("sync", "Start syncing folder to the ipfs network.")
 
+	// restore command
+	restoreCmd := p.NewCommand("restore", "Restore files from the ipfs network.")
+	restorePath := restoreCmd.String("f", "file", &argparse.Options{
+		Required: false,
+		Help:     "Path of the dir or file to restore, will default to the root path.",
+	})
+	target := restoreCmd.String("t", "target", &argparse.Options{
+		Required: true,
+		Help:     "Dir to restore to, the path relative to the root is kept.",
+	})
+	merkleHash := restoreCmd.String("m", "merkle-hash", &argparse.Options{
+		Required: false,
+		Help:     "Merkle root hash of the tree to restore from, will default to the current tree.",
+	})
+	dryRun := restoreCmd.Flag("d", "dry-run", &argparse.Options{
+		Help: "List the files that would be restored without writing them.",
+	})
+	force := restoreCmd.Flag("F", "force", &argparse.Options{
+		Help: "Overwrite files modified since they were synced.",
+	})
+
+	// history command
+	historyCmd := p.NewCommand("history", "List the synced snapshots.")
+	historyPath := historyCmd.String("f", "file", &argparse.Options{
+		Required: false,
+		Help:     "Path of a file to list the versions of.",
+	})
+	fromHash := historyCmd.String("a", "from", &argparse.Options{
+		Required: false,
+		Help:     "Merkle root hash of the snapshot to list the changes from.",
+	})
+	toHash := historyCmd.String("b", "to", &argparse.Options{
+		Required: false,
+		Help:     "Merkle root hash of the snapshot to list the changes to.",
+	})
+
+	// conflicts command
+	conflictsCmd := p.NewCommand("conflicts", "List the conflicts resolved with the peers.")
+
+	// gc command
+	gcCmd := p.NewCommand("gc", "Delete old snapshots and unpin their contents.")
+	gcDryRun := gcCmd.Flag("d", "dry-run", &argparse.Options{
+		Help: "Report what would be deleted and the bytes reclaimed.",
+	})
+
 	// Optional command
 	nodeAddr := p.String("n", "node-addr", &argparse.Options{
 		Required: false,
@@ -74,10 +125,13 @@ func main() {
 		log.Fatal(err)
 	}
 	defer db.CloseDb()
+	if err := vtree.MigratePaths(); err != nil {
+		log.Fatal(err)
+	}
 
 	switch {
 	case initCmd.Happened():
-		err := config.NewConfig(*root, *secretPhrase, *nodeAddr, *p2pPort)
+		err := config.NewConfig(*root, *secretPhrase, *nodeAddr, *p2pPort, *contentStore)
 		if err != nil {
 			log.Fatal(p.Usage(err))
 		}
@@ -91,6 +145,37 @@ func main() {
 		f := initLogger()
 		defer f.Close()
 		sync.Run(c)
+	case restoreCmd.Happened():
+		c, err := config.LoadConfig(*nodeAddr, *p2pPort)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *restorePath == "" {
+			*restorePath = c.Root
+		}
+		if err := sync.Restore(c, *restorePath, *target, *merkleHash, *dryRun, *force); err != nil {
+			log.Fatal(err)
+		}
+	case historyCmd.Happened():
+		c, err := config.LoadConfig(*nodeAddr, *p2pPort)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := sync.History(c, *historyPath, *fromHash, *toHash); err != nil {
+			log.Fatal(err)
+		}
+	case conflictsCmd.Happened():
+		if err := sync.Conflicts(); err != nil {
+			log.Fatal(err)
+		}
+	case gcCmd.Happened():
+		c, err := config.LoadConfig(*nodeAddr, *p2pPort)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := sync.GC(c, *gcDryRun); err != nil {
+			log.Fatal(err)
+		}
 	default:
 		os.Exit(0)
 	}
@@ -1,25 +1,111 @@
 package p2p
 
 import (
+	"context"
 	"errors"
+	"path/filepath"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/vtree"
 )
 
 const (
+	// MerkleHashRequest asks the peer for its vtree merkle root hash.
 	MerkleHashRequest = "MerkleHashRequest"
+
+	// FSTreeRequest asks the peer for its whole vtree.
+	FSTreeRequest = "FSTreeRequest"
+
+	// SubtreeRequest asks the peer for the node at the request path or id
+	// with the request depth levels of links.
+	SubtreeRequest = "SubtreeRequest"
 )
 
 var (
 	ErrLNodeNotInitialized = errors.New("p2p: local node not initialized")
 )
 
-func sendRequest(method string) error {
-	if lnode == nil {
+func sendRequest(method string) ([]*pb.Response, error) {
+	ln := getLNode()
+	if ln == nil {
+		return nil, ErrLNodeNotInitialized
+	}
+	return ln.Request(method), nil
+}
+
+// GetMerkleHash requests the merkle root hash of the connected peers.
+func GetMerkleHash() ([]*pb.Response, error) {
+	return sendRequest(MerkleHashRequest)
+}
+
+// GetFSTree requests the vtree of the connected peers.
+func GetFSTree() ([]*pb.Response, error) {
+	return sendRequest(FSTreeRequest)
+}
+
+// Peers returns the connected peers, none if the local node is not initialized.
+func Peers() []peer.ID {
+	ln := getLNode()
+	if ln == nil {
+		return []peer.ID{}
+	}
+	return ln.GetPeers()
+}
+
+// LocalID returns the peer id of the local node, empty if the local node is
+// not initialized.
+func LocalID() peer.ID {
+	ln := getLNode()
+	if ln == nil {
+		return ""
+	}
+	return ln.GetPeerID()
+}
+
+// FetchTree requests the vtree of the peer, only the subtrees differing
+// from the local tree are transferred.
+func FetchTree(peerID peer.ID, local *pb.FSTree) (*pb.FSTree, error) {
+	ln := getLNode()
+	if ln == nil {
+		return nil, ErrLNodeNotInitialized
+	}
+	return ln.FetchTree(peerID, local)
+}
+
+// ServeVTree registers the handlers answering the peer requests about the vtree.
+func ServeVTree(vt *vtree.VTree) error {
+	ln := getLNode()
+	if ln == nil {
 		return ErrLNodeNotInitialized
 	}
-	lnode.Request(method)
+	ln.ServeVTree(vt)
 	return nil
 }
 
-func GetMerkleHash() error {
-	return sendRequest(MerkleHashRequest)
+// ServeVTree registers the handlers answering the peer requests about the vtree.
+func (rpc *RPC) ServeVTree(vt *vtree.VTree) {
+	rpc.Register(MerkleHashRequest, func(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+		return &pb.Response{Result: &pb.Response_MerkleHash{MerkleHash: vt.MerkleHash()}}, nil
+	})
+	rpc.Register(FSTreeRequest, func(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+		return &pb.Response{Result: &pb.Response_Fstree{Fstree: vt.ToProto()}}, nil
+	})
+	rpc.Register(SubtreeRequest, func(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+		var n *pb.FSNode
+		var err error
+		if req.GetPath() == "" && len(req.GetId()) > 0 {
+			n, err = vt.SubtreeByID(req.GetId(), int(req.GetDepth()))
+		} else {
+			n, err = vt.Subtree(filepath.Join(".", req.GetPath()), int(req.GetDepth()))
+		}
+		if err != nil {
+			return nil, &RemoteError{Code: pb.Error_NOT_FOUND, Message: err.Error()}
+		}
+		return &pb.Response{
+			Result:     &pb.Response_Fsnode{Fsnode: n},
+			MerkleAlgo: vtree.MERKLEALGO,
+			Owner:      vt.Owner(),
+		}, nil
+	})
 }
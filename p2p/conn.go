@@ -1,25 +1,43 @@
 package p2p
 
 import (
+	"sync"
+
 	discovery "github.com/libp2p/go-libp2p-discovery"
 	libp2pdht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/orbit-drive/orbit-drive/sys"
+	"github.com/orbit-drive/orbit-drive/vtree"
 	log "github.com/sirupsen/logrus"
 )
 
-var lnode *LNode
+var (
+	lnode     *LNode
+	lnodeLock sync.RWMutex
+)
+
+// getLNode returns the local node initialized by InitConn, nil if none.
+func getLNode() *LNode {
+	lnodeLock.RLock()
+	defer lnodeLock.RUnlock()
+	return lnode
+}
 
-// InitConn main entry for initialization of p2p connections.
-func InitConn(port, nid string) error {
-	lnode = NewLNode(port, nid)
-	if err := lnode.initHost(); err != nil {
+// InitConn main entry for initialization of p2p connections, the peers
+// requests about the vtree are answered once the host is created.
+func InitConn(port, nid string, vt *vtree.VTree) error {
+	ln := NewLNode(port, nid)
+	if err := ln.initHost(); err != nil {
 		return err
 	}
-	log.WithField("host-id", lnode.ID()).Info("Host created")
+	ln.ServeVTree(vt)
+	lnodeLock.Lock()
+	lnode = ln
+	lnodeLock.Unlock()
+	log.WithField("host-id", ln.ID()).Info("Host created")
 
-	ctx := lnode.GetContext()
+	ctx := ln.GetContext()
 	// Initialize kademlia distributed hash table from LNode host.
-	kademliaDHT, err := libp2pdht.New(ctx, lnode)
+	kademliaDHT, err := libp2pdht.New(ctx, ln)
 	if err != nil {
 		return err
 	}
@@ -28,7 +46,7 @@ func InitConn(port, nid string) error {
 	}
 
 	// Connect lnode to bootstrap libp2p nodes
-	ConnectToBootstrapNodes(lnode)
+	ConnectToBootstrapNodes(ln)
 
 	// TODO: move routing to LNode ?
 	log.Warn("Announcing to peers...")
@@ -45,11 +63,11 @@ func InitConn(port, nid string) error {
 	for peer := range peerChan {
 		log.WithField("peer-id", peer.ID).Info("Peer discovered!")
 
-		if peer.ID == lnode.ID() {
+		if peer.ID == ln.ID() {
 			continue
 		}
 
-		lnode.AddPeer(peer.ID)
+		ln.AddPeer(peer.ID)
 		sys.Notify("Peer connected: ", string(peer.ID))
 	}
 
@@ -0,0 +1,108 @@
+package p2p
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/vtree"
+)
+
+// GetSubtree requests the node at the path relative to the root of the peer
+// vtree with depth levels of links, all the links for a depth of 0.
+func (rpc *RPC) GetSubtree(peerID peer.ID, path string, depth int) (*pb.Response, error) {
+	req := rpc.createReq(SubtreeRequest)
+	req.Path = path
+	req.Depth = int32(depth)
+	return rpc.send(peerID, req)
+}
+
+// GetSubtreeByID requests the node with the given id in the peer vtree with
+// depth levels of links, all the links for a depth of 0.
+func (rpc *RPC) GetSubtreeByID(peerID peer.ID, id []byte, depth int) (*pb.Response, error) {
+	req := rpc.createReq(SubtreeRequest)
+	req.Id = id
+	req.Depth = int32(depth)
+	return rpc.send(peerID, req)
+}
+
+// FetchTree returns the vtree of the peer, only the dirs whose merkle hash
+// differs from the local tree are requested level by level, the nodes
+// matching are reused from the local tree. The whole vtree is requested
+// when the peer uses another merkle scheme.
+func (rpc *RPC) FetchTree(peerID peer.ID, local *pb.FSTree) (*pb.FSTree, error) {
+	resp, err := rpc.GetSubtree(peerID, ".", 1)
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetMerkleAlgo() != vtree.MERKLEALGO {
+		resp, err := rpc.RequestToPeer(peerID, FSTreeRequest)
+		if err != nil {
+			return nil, err
+		}
+		return resp.GetFstree(), nil
+	}
+
+	var head *pb.FSNode
+	if local.GetMerkleAlgo() == vtree.MERKLEALGO {
+		head = local.GetHead()
+	}
+	head, err = rpc.merge(peerID, head, resp.GetFsnode())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.FSTree{Owner: resp.GetOwner(), Head: head, MerkleAlgo: vtree.MERKLEALGO}, nil
+}
+
+// merge returns the peer node built from its first level received, the
+// local nodes with the same merkle hash are reused and the differing dirs
+// are requested recursively. New dirs are requested with all their links.
+func (rpc *RPC) merge(peerID peer.ID, local, remote *pb.FSNode) (*pb.FSNode, error) {
+	if sameNode(local, remote) {
+		return local, nil
+	}
+	if remote.GetType() != pb.FSNode_DIR {
+		return remote, nil
+	}
+
+	locals := make(map[string]*pb.FSNode)
+	for _, link := range local.GetLinks() {
+		locals[link.GetPath()] = link
+	}
+	for i, link := range remote.GetLinks() {
+		l := locals[link.GetPath()]
+		if link.GetType() != pb.FSNode_DIR || link.GetMerkleHash() == "" || sameNode(l, link) {
+			remote.Links[i] = pickNode(l, link)
+			continue
+		}
+
+		depth := 1
+		if l.GetType() != pb.FSNode_DIR {
+			// No local dir to compare with, all its links are needed.
+			depth = 0
+		}
+		resp, err := rpc.GetSubtree(peerID, link.GetPath(), depth)
+		if err != nil {
+			return nil, err
+		}
+		if depth == 0 {
+			remote.Links[i] = resp.GetFsnode()
+			continue
+		}
+		if remote.Links[i], err = rpc.merge(peerID, l, resp.GetFsnode()); err != nil {
+			return nil, err
+		}
+	}
+	return remote, nil
+}
+
+// sameNode reports whether the local node has the type and merkle hash of the remote node.
+func sameNode(local, remote *pb.FSNode) bool {
+	return local != nil && local.GetType() == remote.GetType() && local.GetMerkleHash() == remote.GetMerkleHash()
+}
+
+// pickNode returns the local node when matching the remote node, the remote node otherwise.
+func pickNode(local, remote *pb.FSNode) *pb.FSNode {
+	if sameNode(local, remote) {
+		return local
+	}
+	return remote
+}
@@ -0,0 +1,154 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/vtree"
+)
+
+// genTree returns the protobuf of a vtree of the given depth with fanout
+// dirs and the given number of files per dir, and its deepest file path.
+func genTree(depth, fanout, files int) (*pb.FSTree, string) {
+	vt := vtree.NewVTree("", nil)
+	var deepest string
+	var gen func(dir *vtree.VNode, depth int)
+	gen = func(dir *vtree.VNode, depth int) {
+		for i := 0; i < files; i++ {
+			n := dir.NewVNode(filepath.Join(dir.Path, fmt.Sprintf("file%d", i)))
+			n.SetSource(&db.Source{Size: int64(i), Checksum: fmt.Sprintf("%032x", i), Mode: 0644})
+			deepest = n.Path
+		}
+		if depth == 0 {
+			return
+		}
+		for i := 0; i < fanout; i++ {
+			n := dir.NewVNode(filepath.Join(dir.Path, fmt.Sprintf("dir%d", i)))
+			n.SetAsDir()
+			gen(n, depth-1)
+		}
+	}
+	gen(vt.Head, depth)
+	return proto.Clone(vt.ToProto()).(*pb.FSTree), deepest
+}
+
+// findNode returns the protobuf node at path under n, nil if none.
+func findNode(n *pb.FSNode, path string) *pb.FSNode {
+	if n.GetPath() == path {
+		return n
+	}
+	for _, link := range n.GetLinks() {
+		if found := findNode(link, path); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// serveTree serves the vtree built from the tree on the node, the returned
+// counter is incremented on each subtree request handled.
+func serveTree(ln *LNode, fst *pb.FSTree) (*vtree.VTree, *int32) {
+	vt := vtree.NewVTreeFromProto(fst, nil)
+	ln.ServeVTree(vt)
+	var count int32
+	h := ln.handlers[SubtreeRequest]
+	ln.Register(SubtreeRequest, func(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+		atomic.AddInt32(&count, 1)
+		return h(ctx, req)
+	})
+	return vt, &count
+}
+
+func TestFetchTree(t *testing.T) {
+	client, server := newTestLNode(t), newTestLNode(t)
+	defer client.Close()
+	defer server.Close()
+	connect(t, client, server)
+
+	const depth = 4
+	fst, deepest := genTree(depth, 4, 5)
+	local := vtree.NewVTreeFromProto(proto.Clone(fst).(*pb.FSTree), nil).ToProto()
+
+	modified := proto.Clone(fst).(*pb.FSTree)
+	findNode(modified.Head, deepest).Checksum = "modified"
+	vt := vtree.NewVTreeFromProto(proto.Clone(fst).(*pb.FSTree), nil)
+	dir := vt.Head.NewVNode("new")
+	dir.SetAsDir()
+	dir.NewVNode("new/file0").SetSource(&db.Source{Size: 1, Checksum: "new", Mode: 0644})
+	added := proto.Clone(vt.ToProto()).(*pb.FSTree)
+
+	tests := []struct {
+		name     string
+		tree     *pb.FSTree
+		requests int32
+		changes  []vtree.State
+	}{
+		{"identical", fst, 1, []vtree.State{}},
+		{"modified", modified, depth + 1, []vtree.State{{Path: deepest, Op: vtree.ModifiedOp}}},
+		{"added", added, 2, []vtree.State{{Path: "new", Op: vtree.AddedOp}}},
+	}
+	for _, tt := range tests {
+		vt, count := serveTree(server, proto.Clone(tt.tree).(*pb.FSTree))
+		remote, err := client.FetchTree(server.ID(), local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(count); n != tt.requests {
+			t.Errorf("%s: Expected %d subtree requests, got: %d", tt.name, tt.requests, n)
+		}
+		if h := remote.GetHead().GetMerkleHash(); h != vt.MerkleHash() {
+			t.Errorf("%s: Expected fetched tree hash %s, got: %s", tt.name, vt.MerkleHash(), h)
+		}
+		if !proto.Equal(remote.GetHead(), vt.ToProto().GetHead()) {
+			t.Errorf("%s: Expected fetched tree to match the peer tree", tt.name)
+		}
+		states := []vtree.State{}
+		for _, change := range vtree.Diff(local, remote) {
+			states = append(states, change.State)
+		}
+		if fmt.Sprint(states) != fmt.Sprint(tt.changes) {
+			t.Errorf("%s: Expected changes %v, got: %v", tt.name, tt.changes, states)
+		}
+	}
+}
+
+func TestGetSubtree(t *testing.T) {
+	client, server := newTestLNode(t), newTestLNode(t)
+	defer client.Close()
+	defer server.Close()
+	connect(t, client, server)
+
+	fst, _ := genTree(2, 2, 1)
+	serveTree(server, fst)
+
+	resp, err := client.GetSubtree(server.ID(), "dir1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := resp.GetFsnode()
+	if n.GetPath() != "dir1" || len(n.GetLinks()) != 3 || len(n.GetLinks()[1].GetLinks()) != 0 {
+		t.Errorf("Expected dir1 with 3 links cut at depth 1, got: %+v", n)
+	}
+	if resp.GetMerkleAlgo() != vtree.MERKLEALGO {
+		t.Errorf("Expected merkle algo %s, got: %s", vtree.MERKLEALGO, resp.GetMerkleAlgo())
+	}
+
+	resp, err = client.GetSubtreeByID(server.ID(), n.GetID(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := resp.GetFsnode(); n.GetPath() != "dir1" || len(n.GetLinks()[1].GetLinks()) != 1 {
+		t.Errorf("Expected dir1 with all its links, got: %+v", n)
+	}
+
+	_, err = client.GetSubtree(server.ID(), "missing", 1)
+	if e, ok := err.(*RemoteError); !ok || e.Code != pb.Error_NOT_FOUND {
+		t.Errorf("Expected %s error, got: %v", pb.Error_NOT_FOUND, err)
+	}
+}
@@ -16,7 +16,7 @@ import (
 // LNode represents a local node connection and is a wrapper around libp2p Host.
 type LNode struct {
 	host.Host
-	RPC
+	*RPC
 
 	// Port for tcp connection of local node to listen to.
 	Port string
@@ -25,20 +25,24 @@ type LNode struct {
 	NID string
 
 	// Peers is the list of connected peers under the same NID.
-	Peers []peer.ID
+	Peers     []peer.ID
+	peersLock sync.RWMutex
 
-	// Ctx context for cancellation signal ?
-	ctx context.Context
+	// ctx is cancelled when the local node is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewLNode(port, nid string) *LNode {
+	ctx, cancel := context.WithCancel(context.Background())
 	lnode := &LNode{
-		Port:  port,
-		NID:   nid,
-		Peers: []peer.ID{},
-		ctx:   context.Background(),
+		Port:   port,
+		NID:    nid,
+		Peers:  []peer.ID{},
+		ctx:    ctx,
+		cancel: cancel,
 	}
-	lnode.RPC = *NewRpc(lnode)
+	lnode.RPC = NewRpc(lnode)
 	return lnode
 }
 
@@ -54,9 +58,29 @@ func (ln *LNode) GetContext() context.Context {
 
 // AddPeer adds a new peer id to the list of connected peer id.
 func (ln *LNode) AddPeer(pid peer.ID) {
+	ln.peersLock.Lock()
+	defer ln.peersLock.Unlock()
 	ln.Peers = append(ln.Peers, pid)
 }
 
+// GetPeers returns a copy of the list of connected peer id.
+func (ln *LNode) GetPeers() []peer.ID {
+	ln.peersLock.RLock()
+	defer ln.peersLock.RUnlock()
+	return append([]peer.ID{}, ln.Peers...)
+}
+
+// Close cancels the requests in flight, waits for them to return and
+// closes the host streams and connections.
+func (ln *LNode) Close() error {
+	ln.cancel()
+	ln.RPC.wait()
+	if ln.Host == nil {
+		return nil
+	}
+	return ln.Host.Close()
+}
+
 func (ln *LNode) initHost() error {
 	addr := fmt.Sprintf("/ip4/127.0.0.1/tcp/%s", ln.Port)
 	listenMAddr, _ := maddr.NewMultiaddr(addr)
@@ -72,12 +96,14 @@ func (ln *LNode) initHost() error {
 	return nil
 }
 
-// Request send a rpc call to connected peers.
-func (ln *LNode) Request(method string) {
+// Request send a rpc call to connected peers and returns the responses
+// received, the failed requests are logged.
+func (ln *LNode) Request(method string) []*pb.Response {
 	var responses []*pb.Response
+	var lock sync.Mutex
 	var wg sync.WaitGroup
 
-	for _, peerID := range ln.Peers {
+	for _, peerID := range ln.GetPeers() {
 		log.WithFields(log.Fields{
 			"peer-id": peerID,
 			"method":  method,
@@ -85,15 +111,18 @@ func (ln *LNode) Request(method string) {
 
 		wg.Add(1)
 		go func(pid peer.ID) {
+			defer wg.Done()
 			respPb, err := ln.RequestToPeer(pid, method)
 			if err != nil {
-				log.Warn(err)
+				log.WithField("peer-id", pid).Warn(err)
 				return
 			}
+			lock.Lock()
 			responses = append(responses, respPb)
+			lock.Unlock()
 		}(peerID)
 	}
 
 	wg.Wait()
-	log.Println(responses)
+	return responses
 }
@@ -2,6 +2,11 @@ package p2p
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	inet "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
@@ -18,8 +23,38 @@ const (
 
 	// ProtocolResponseID - protocol header id for response traffic
 	ProtocolResponseID string = "/od/syncresp/1.0.0"
+
+	// DefaultRequestTimeout is the default max duration of a request,
+	// from sending it to receiving its response.
+	DefaultRequestTimeout = 30 * time.Second
+)
+
+var (
+	// ErrUnknownMethod is returned when no handler is registered for the request method.
+	ErrUnknownMethod = errors.New("p2p: unknown method")
+
+	// ErrRequestTimeout is returned when no response is received before the request deadline.
+	ErrRequestTimeout = errors.New("p2p: request timed out")
+
+	// ErrLNodeClosed is returned for the requests cancelled or sent after the local node is closed.
+	ErrLNodeClosed = errors.New("p2p: local node closed")
 )
 
+// Handler handles a request received from a peer and returns the response
+// holding the result, the response ids are set by the RPC. The context is
+// cancelled when the request deadline is reached or the local node is closed.
+type Handler func(ctx context.Context, req *pb.Request) (*pb.Response, error)
+
+// RemoteError represents the error returned by a peer in a response.
+type RemoteError struct {
+	Code    pb.Error_Code
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("p2p: peer returned %s error: %s", e.Code, e.Message)
+}
+
 type ReqResp struct {
 	requestPb *pb.Request
 	respChan  chan *pb.Response
@@ -28,7 +63,7 @@ type ReqResp struct {
 func newReqResp(reqPb *pb.Request) *ReqResp {
 	return &ReqResp{
 		requestPb: reqPb,
-		respChan:  make(chan *pb.Response),
+		respChan:  make(chan *pb.Response, 1),
 	}
 }
 
@@ -37,19 +72,32 @@ func newReqResp(reqPb *pb.Request) *ReqResp {
 type RPC struct {
 	lnode *LNode
 
-	// TODO: Might need to create a self contained context queue to timeout request pending for x amount of seconds.
-	// ReqOut represents the queue outgoing requests from the current node.
-	ReqOut map[string]*ReqResp
+	// Timeout is the max duration of the outgoing and incoming requests.
+	Timeout time.Duration
+
+	// ReqOut represents the queue outgoing requests from the current node,
+	// a request is removed once resolved or timed out.
+	ReqOut     map[string]*ReqResp
+	reqOutLock sync.Mutex
+
+	// inflight counts the requests sent or handled not resolved yet.
+	inflight sync.WaitGroup
 
 	// ReqIn represents a chan of incoming request to process.
 	ReqIn chan *pb.Request
+
+	// handlers holds the request handlers by method.
+	handlers     map[string]Handler
+	handlersLock sync.RWMutex
 }
 
 func NewRpc(lnode *LNode) *RPC {
 	return &RPC{
-		lnode:  lnode,
-		ReqOut: make(map[string]*ReqResp),
-		ReqIn:  make(chan *pb.Request),
+		lnode:    lnode,
+		Timeout:  DefaultRequestTimeout,
+		ReqOut:   make(map[string]*ReqResp),
+		ReqIn:    make(chan *pb.Request),
+		handlers: make(map[string]Handler),
 	}
 }
 
@@ -63,6 +111,14 @@ func (rpc *RPC) ResponseID() protocol.ID {
 	return protocol.ID(ProtocolResponseID)
 }
 
+// Register sets the handler of the requests with the given method,
+// replacing the handler registered before.
+func (rpc *RPC) Register(method string, h Handler) {
+	rpc.handlersLock.Lock()
+	defer rpc.handlersLock.Unlock()
+	rpc.handlers[method] = h
+}
+
 func (rpc *RPC) initHandlers() {
 	rpc.lnode.SetStreamHandler(rpc.RequestID(), rpc.reqHandler)
 	rpc.lnode.SetStreamHandler(rpc.ResponseID(), rpc.respHandler)
@@ -70,7 +126,7 @@ func (rpc *RPC) initHandlers() {
 
 func (rpc *RPC) createReq(method string) *pb.Request {
 	return &pb.Request{
-		PeerId:    string(rpc.lnode.GetPeerID()),
+		PeerId:    rpc.lnode.GetPeerID().Pretty(),
 		RequestId: utils.RandUUID(),
 		Method:    method,
 	}
@@ -78,11 +134,15 @@ func (rpc *RPC) createReq(method string) *pb.Request {
 
 func (rpc *RPC) registerReqOut(reqPb *pb.Request) *ReqResp {
 	reqResp := newReqResp(reqPb)
+	rpc.reqOutLock.Lock()
 	rpc.ReqOut[reqPb.GetRequestId()] = reqResp
+	rpc.reqOutLock.Unlock()
 	return reqResp
 }
 
 func (rpc *RPC) findReqOut(reqID string) *ReqResp {
+	rpc.reqOutLock.Lock()
+	defer rpc.reqOutLock.Unlock()
 	reqResp, ok := rpc.ReqOut[reqID]
 	if ok {
 		return reqResp
@@ -90,29 +150,117 @@ func (rpc *RPC) findReqOut(reqID string) *ReqResp {
 	return nil
 }
 
-// RequestToPeer opens a stream to a single peer and sends a proto request.
+func (rpc *RPC) removeReqOut(reqID string) {
+	rpc.reqOutLock.Lock()
+	delete(rpc.ReqOut, reqID)
+	rpc.reqOutLock.Unlock()
+}
+
+// PendingCount returns the number of outgoing requests waiting for a response.
+func (rpc *RPC) PendingCount() int {
+	rpc.reqOutLock.Lock()
+	defer rpc.reqOutLock.Unlock()
+	return len(rpc.ReqOut)
+}
+
+// begin returns the context of a new request, cancelled after the Timeout
+// or when the local node is closed. ErrLNodeClosed is returned once closed.
+func (rpc *RPC) begin() (context.Context, context.CancelFunc, error) {
+	// Locked so no request begins after close started waiting.
+	rpc.reqOutLock.Lock()
+	defer rpc.reqOutLock.Unlock()
+	if rpc.lnode.GetContext().Err() != nil {
+		return nil, nil, ErrLNodeClosed
+	}
+	rpc.inflight.Add(1)
+	ctx, cancel := context.WithTimeout(rpc.lnode.GetContext(), rpc.Timeout)
+	return ctx, func() {
+		cancel()
+		rpc.inflight.Done()
+	}, nil
+}
+
+// wait blocks until the requests in flight are resolved, the local node
+// context must be cancelled before.
+func (rpc *RPC) wait() {
+	// Requests beginning after the lock is released see the cancelled
+	// context, so none is added to inflight while waiting.
+	rpc.reqOutLock.Lock()
+	rpc.reqOutLock.Unlock()
+	rpc.inflight.Wait()
+}
+
+// ctxErr returns ErrLNodeClosed or ErrRequestTimeout for a done request context.
+func (rpc *RPC) ctxErr(ctx context.Context) error {
+	if rpc.lnode.GetContext().Err() != nil {
+		return ErrLNodeClosed
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrRequestTimeout
+	}
+	return ctx.Err()
+}
+
+// RequestToPeer opens a stream to a single peer and sends a proto request,
+// the error of the response is returned as a RemoteError. The request is
+// cancelled after the Timeout or when the local node is closed.
 func (rpc *RPC) RequestToPeer(peerID peer.ID, method string) (*pb.Response, error) {
-	stream, err := rpc.lnode.NewStream(rpc.lnode.GetContext(), peerID, rpc.RequestID())
+	return rpc.send(peerID, rpc.createReq(method))
+}
+
+// send opens a stream to the peer, sends the request and waits for its response.
+func (rpc *RPC) send(peerID peer.ID, requestPayload *pb.Request) (*pb.Response, error) {
+	ctx, done, err := rpc.begin()
 	if err != nil {
 		return nil, err
 	}
+	defer done()
 
-	writer := bufio.NewWriter(stream)
-	requestPayload := rpc.createReq(method)
-
-	enc := protobufCodec.Multicodec(nil).Encoder(writer)
-	if err = enc.Encode(requestPayload); err != nil {
+	stream, err := rpc.lnode.NewStream(ctx, peerID, rpc.RequestID())
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, rpc.ctxErr(ctx)
+		}
 		return nil, err
 	}
-	writer.Flush()
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
 
+	// Registered before sending so the response can not arrive first.
 	reqResp := rpc.registerReqOut(requestPayload)
-	respPb := <-reqResp.respChan
-	return respPb, nil
+	defer rpc.removeReqOut(requestPayload.GetRequestId())
+	if err := writeMsg(stream, requestPayload); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	select {
+	case respPb := <-reqResp.respChan:
+		if e := respPb.GetError(); e != nil {
+			return respPb, &RemoteError{Code: e.GetCode(), Message: e.GetMessage()}
+		}
+		return respPb, nil
+	case <-ctx.Done():
+		stream.Reset()
+		return nil, rpc.ctxErr(ctx)
+	}
 }
 
 // reqHandler: remote peer request handler (received request from peer)
 func (rpc *RPC) reqHandler(s inet.Stream) {
+	defer s.Close()
+	ctx, done, err := rpc.begin()
+	if err != nil {
+		s.Reset()
+		return
+	}
+	defer done()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
 	req := &pb.Request{}
 	reader := bufio.NewReader(s)
 	decoder := protobufCodec.Multicodec(nil).Decoder(reader)
@@ -125,9 +273,53 @@ func (rpc *RPC) reqHandler(s inet.Stream) {
 		"req-id":  req.GetRequestId(),
 		"method":  req.GetMethod(),
 	}).Info("Received request from peer")
+
+	resp := rpc.dispatch(ctx, req)
+	resp.PeerId = rpc.lnode.GetPeerID().Pretty()
+	resp.RequestId = req.GetRequestId()
+	if err := rpc.respond(ctx, s.Conn().RemotePeer(), resp); err != nil {
+		log.WithField("req-id", req.GetRequestId()).Warn(err)
+	}
+}
+
+// dispatch calls the handler registered for the request method, errors are
+// returned in the response with the code of the RemoteError if any.
+func (rpc *RPC) dispatch(ctx context.Context, req *pb.Request) *pb.Response {
+	rpc.handlersLock.RLock()
+	h, ok := rpc.handlers[req.GetMethod()]
+	rpc.handlersLock.RUnlock()
+	if !ok {
+		return errorResponse(pb.Error_UNKNOWN_METHOD, fmt.Sprintf("%s: %s", ErrUnknownMethod, req.GetMethod()))
+	}
+
+	resp, err := h(ctx, req)
+	if e, ok := err.(*RemoteError); ok {
+		return errorResponse(e.Code, e.Message)
+	}
+	if err != nil {
+		return errorResponse(pb.Error_INTERNAL, err.Error())
+	}
+	if resp == nil {
+		resp = &pb.Response{}
+	}
+	return resp
+}
+
+// respond opens a response stream to the peer and sends the response.
+func (rpc *RPC) respond(ctx context.Context, peerID peer.ID, resp *pb.Response) error {
+	stream, err := rpc.lnode.NewStream(ctx, peerID, rpc.ResponseID())
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	return writeMsg(stream, resp)
 }
 
 func (rpc *RPC) respHandler(s inet.Stream) {
+	defer s.Close()
 	resp := &pb.Response{}
 	reader := bufio.NewReader(s)
 	decoder := protobufCodec.Multicodec(nil).Decoder(reader)
@@ -138,7 +330,11 @@ func (rpc *RPC) respHandler(s inet.Stream) {
 
 	reqResp := rpc.findReqOut(resp.GetRequestId())
 	if reqResp != nil {
-		reqResp.respChan <- resp
+		select {
+		case reqResp.respChan <- resp:
+		default:
+			// Duplicated response, the request is already resolved.
+		}
 		return
 	}
 
@@ -147,3 +343,19 @@ func (rpc *RPC) respHandler(s inet.Stream) {
 		"request-uuid": resp.GetRequestId(),
 	}).Warn("Received response from peer with no corresponding request")
 }
+
+// writeMsg encodes the proto message to the stream.
+func writeMsg(s inet.Stream, msg interface{}) error {
+	writer := bufio.NewWriter(s)
+	enc := protobufCodec.Multicodec(nil).Encoder(writer)
+	if err := enc.Encode(msg); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func errorResponse(code pb.Error_Code, msg string) *pb.Response {
+	return &pb.Response{
+		Result: &pb.Response_Error{Error: &pb.Error{Code: code, Message: msg}},
+	}
+}
@@ -0,0 +1,140 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/orbit-drive/orbit-drive/pb"
+)
+
+// newTestLNode starts a local node listening on a random local port.
+func newTestLNode(t *testing.T) *LNode {
+	ln := NewLNode("0", "orbit-drive-test")
+	if err := ln.initHost(); err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+// connect connects the local node to the peer and adds it to its peers.
+func connect(t *testing.T, ln, p *LNode) {
+	info := peerstore.PeerInfo{ID: p.ID(), Addrs: p.Addrs()}
+	if err := ln.Connect(ln.GetContext(), info); err != nil {
+		t.Fatal(err)
+	}
+	ln.AddPeer(p.ID())
+}
+
+func TestRPC(t *testing.T) {
+	client, server := newTestLNode(t), newTestLNode(t)
+	defer client.Close()
+	defer server.Close()
+	connect(t, client, server)
+
+	server.Register(MerkleHashRequest, func(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+		return &pb.Response{Result: &pb.Response_MerkleHash{MerkleHash: "hash"}}, nil
+	})
+	server.Register("Failing", func(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+		return nil, errors.New("failed")
+	})
+
+	resp, err := client.RequestToPeer(server.ID(), MerkleHashRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetMerkleHash() != "hash" || resp.GetPeerId() != server.ID().Pretty() || resp.GetRequestId() == "" {
+		t.Errorf("Expected merkle hash response from server, got: %+v", resp)
+	}
+
+	tests := map[string]pb.Error_Code{
+		"Unknown": pb.Error_UNKNOWN_METHOD,
+		"Failing": pb.Error_INTERNAL,
+	}
+	for method, code := range tests {
+		_, err := client.RequestToPeer(server.ID(), method)
+		if e, ok := err.(*RemoteError); !ok || e.Code != code {
+			t.Errorf("Expected %s error for %s method, got: %v", code, method, err)
+		}
+	}
+
+	responses := client.Request(MerkleHashRequest)
+	if len(responses) != 1 || responses[0].GetMerkleHash() != "hash" {
+		t.Errorf("Expected 1 merkle hash response, got: %+v", responses)
+	}
+}
+
+// blockingHandler blocks until its context is done.
+func blockingHandler(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRequestTimeout(t *testing.T) {
+	client, server := newTestLNode(t), newTestLNode(t)
+	defer client.Close()
+	defer server.Close()
+	connect(t, client, server)
+	server.Register("Blocking", blockingHandler)
+
+	client.Timeout = 200 * time.Millisecond
+	start := time.Now()
+	if _, err := client.RequestToPeer(server.ID(), "Blocking"); err != ErrRequestTimeout {
+		t.Errorf("Expected %v, got: %v", ErrRequestTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected request to time out after %v, took: %v", client.Timeout, elapsed)
+	}
+	if n := client.PendingCount(); n != 0 {
+		t.Errorf("Expected timed out request to be removed, got: %d pending", n)
+	}
+}
+
+func TestClose(t *testing.T) {
+	client, server := newTestLNode(t), newTestLNode(t)
+	connect(t, client, server)
+	server.Register("Blocking", blockingHandler)
+
+	errs := make(chan error, 2)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := client.RequestToPeer(server.ID(), "Blocking")
+			errs <- err
+		}()
+	}
+	for client.PendingCount() != cap(errs) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- client.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected close to cancel the requests in flight")
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != ErrLNodeClosed {
+			t.Errorf("Expected %v, got: %v", ErrLNodeClosed, err)
+		}
+	}
+	if n := client.PendingCount(); n != 0 {
+		t.Errorf("Expected cancelled requests to be removed, got: %d pending", n)
+	}
+	if _, err := client.RequestToPeer(server.ID(), "Blocking"); err != ErrLNodeClosed {
+		t.Errorf("Expected %v after close, got: %v", ErrLNodeClosed, err)
+	}
+
+	// The handlers in flight are cancelled too.
+	go func() { closed <- server.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected close to cancel the handlers in flight")
+	}
+}
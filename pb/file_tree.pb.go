@@ -25,16 +25,19 @@ type FSNode_Type int32
 const (
 	FSNode_FILE FSNode_Type = 0
 	FSNode_DIR  FSNode_Type = 1
+	FSNode_LINK FSNode_Type = 2
 )
 
 var FSNode_Type_name = map[int32]string{
 	0: "FILE",
 	1: "DIR",
+	2: "LINK",
 }
 
 var FSNode_Type_value = map[string]int32{
 	"FILE": 0,
 	"DIR":  1,
+	"LINK": 2,
 }
 
 func (x FSNode_Type) String() string {
@@ -46,13 +49,21 @@ func (FSNode_Type) EnumDescriptor() ([]byte, []int) {
 }
 
 type FSNode struct {
-	ID                   []byte    `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
-	Path                 string    `protobuf:"bytes,2,opt,name=Path,proto3" json:"Path,omitempty"`
-	Links                []*FSNode `protobuf:"bytes,4,rep,name=Links,proto3" json:"Links,omitempty"`
-	Source               string    `protobuf:"bytes,5,opt,name=Source,proto3" json:"Source,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
-	XXX_unrecognized     []byte    `json:"-"`
-	XXX_sizecache        int32     `json:"-"`
+	ID                   []byte      `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Path                 string      `protobuf:"bytes,2,opt,name=Path,proto3" json:"Path,omitempty"`
+	Type                 FSNode_Type `protobuf:"varint,3,opt,name=type,proto3,enum=pb.FSNode_Type" json:"type,omitempty"`
+	Links                []*FSNode   `protobuf:"bytes,4,rep,name=Links,proto3" json:"Links,omitempty"`
+	Source               string      `protobuf:"bytes,5,opt,name=Source,proto3" json:"Source,omitempty"`
+	Size                 int64       `protobuf:"varint,6,opt,name=Size,proto3" json:"Size,omitempty"`
+	Checksum             string      `protobuf:"bytes,7,opt,name=Checksum,proto3" json:"Checksum,omitempty"`
+	MerkleHash           string      `protobuf:"bytes,8,opt,name=MerkleHash,proto3" json:"MerkleHash,omitempty"`
+	Mode                 uint32      `protobuf:"varint,9,opt,name=Mode,proto3" json:"Mode,omitempty"`
+	ModTime              int64       `protobuf:"varint,10,opt,name=ModTime,proto3" json:"ModTime,omitempty"`
+	Executable           bool        `protobuf:"varint,11,opt,name=Executable,proto3" json:"Executable,omitempty"`
+	Target               string      `protobuf:"bytes,12,opt,name=Target,proto3" json:"Target,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
 }
 
 func (m *FSNode) Reset()         { *m = FSNode{} }
@@ -94,6 +105,13 @@ func (m *FSNode) GetPath() string {
 	return ""
 }
 
+func (m *FSNode) GetType() FSNode_Type {
+	if m != nil {
+		return m.Type
+	}
+	return FSNode_FILE
+}
+
 func (m *FSNode) GetLinks() []*FSNode {
 	if m != nil {
 		return m.Links
@@ -108,9 +126,63 @@ func (m *FSNode) GetSource() string {
 	return ""
 }
 
+func (m *FSNode) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *FSNode) GetChecksum() string {
+	if m != nil {
+		return m.Checksum
+	}
+	return ""
+}
+
+func (m *FSNode) GetMerkleHash() string {
+	if m != nil {
+		return m.MerkleHash
+	}
+	return ""
+}
+
+func (m *FSNode) GetMode() uint32 {
+	if m != nil {
+		return m.Mode
+	}
+	return 0
+}
+
+func (m *FSNode) GetModTime() int64 {
+	if m != nil {
+		return m.ModTime
+	}
+	return 0
+}
+
+func (m *FSNode) GetExecutable() bool {
+	if m != nil {
+		return m.Executable
+	}
+	return false
+}
+
+func (m *FSNode) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
 type FSTree struct {
-	Owner                string   `protobuf:"bytes,1,opt,name=Owner,proto3" json:"Owner,omitempty"`
-	Head                 *FSNode  `protobuf:"bytes,2,opt,name=Head,proto3" json:"Head,omitempty"`
+	Owner string  `protobuf:"bytes,1,opt,name=Owner,proto3" json:"Owner,omitempty"`
+	Head  *FSNode `protobuf:"bytes,2,opt,name=Head,proto3" json:"Head,omitempty"`
+	// Root is the absolute path of the root on the device saving the tree,
+	// the node paths are relative to it.
+	Root string `protobuf:"bytes,3,opt,name=Root,proto3" json:"Root,omitempty"`
+	// MerkleAlgo identifies the scheme of the node merkle hashes.
+	MerkleAlgo           string   `protobuf:"bytes,4,opt,name=MerkleAlgo,proto3" json:"MerkleAlgo,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -155,27 +227,118 @@ func (m *FSTree) GetHead() *FSNode {
 	return nil
 }
 
+func (m *FSTree) GetRoot() string {
+	if m != nil {
+		return m.Root
+	}
+	return ""
+}
+
+func (m *FSTree) GetMerkleAlgo() string {
+	if m != nil {
+		return m.MerkleAlgo
+	}
+	return ""
+}
+
+type Snapshot struct {
+	Timestamp  int64   `protobuf:"varint,1,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+	MerkleHash string  `protobuf:"bytes,2,opt,name=MerkleHash,proto3" json:"MerkleHash,omitempty"`
+	Tree       *FSTree `protobuf:"bytes,3,opt,name=Tree,proto3" json:"Tree,omitempty"`
+	// MerkleAlgo identifies the scheme of the merkle hash.
+	MerkleAlgo           string   `protobuf:"bytes,4,opt,name=MerkleAlgo,proto3" json:"MerkleAlgo,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Snapshot) Reset()         { *m = Snapshot{} }
+func (m *Snapshot) String() string { return proto.CompactTextString(m) }
+func (*Snapshot) ProtoMessage()    {}
+func (*Snapshot) Descriptor() ([]byte, []int) {
+	return fileDescriptor_718d290bcea536a3, []int{2}
+}
+
+func (m *Snapshot) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Snapshot.Unmarshal(m, b)
+}
+func (m *Snapshot) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Snapshot.Marshal(b, m, deterministic)
+}
+func (m *Snapshot) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Snapshot.Merge(m, src)
+}
+func (m *Snapshot) XXX_Size() int {
+	return xxx_messageInfo_Snapshot.Size(m)
+}
+func (m *Snapshot) XXX_DiscardUnknown() {
+	xxx_messageInfo_Snapshot.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Snapshot proto.InternalMessageInfo
+
+func (m *Snapshot) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *Snapshot) GetMerkleHash() string {
+	if m != nil {
+		return m.MerkleHash
+	}
+	return ""
+}
+
+func (m *Snapshot) GetTree() *FSTree {
+	if m != nil {
+		return m.Tree
+	}
+	return nil
+}
+
+func (m *Snapshot) GetMerkleAlgo() string {
+	if m != nil {
+		return m.MerkleAlgo
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterEnum("pb.FSNode_Type", FSNode_Type_name, FSNode_Type_value)
 	proto.RegisterType((*FSNode)(nil), "pb.FSNode")
 	proto.RegisterType((*FSTree)(nil), "pb.FSTree")
+	proto.RegisterType((*Snapshot)(nil), "pb.Snapshot")
 }
 
 func init() { proto.RegisterFile("file_tree.proto", fileDescriptor_718d290bcea536a3) }
 
 var fileDescriptor_718d290bcea536a3 = []byte{
-	// 200 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4f, 0xcb, 0xcc, 0x49,
-	0x8d, 0x2f, 0x29, 0x4a, 0x4d, 0xd5, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2a, 0x48, 0x52,
-	0x6a, 0x64, 0xe4, 0x62, 0x73, 0x0b, 0xf6, 0xcb, 0x4f, 0x49, 0x15, 0xe2, 0xe3, 0x62, 0xf2, 0x74,
-	0x91, 0x60, 0x54, 0x60, 0xd4, 0xe0, 0x09, 0x62, 0xf2, 0x74, 0x11, 0x12, 0xe2, 0x62, 0x09, 0x48,
-	0x2c, 0xc9, 0x90, 0x60, 0x52, 0x60, 0xd4, 0xe0, 0x0c, 0x02, 0xb3, 0x85, 0x14, 0xb8, 0x58, 0x7d,
-	0x32, 0xf3, 0xb2, 0x8b, 0x25, 0x58, 0x14, 0x98, 0x35, 0xb8, 0x8d, 0xb8, 0xf4, 0x0a, 0x92, 0xf4,
-	0x20, 0xda, 0x83, 0x20, 0x12, 0x42, 0x62, 0x5c, 0x6c, 0xc1, 0xf9, 0xa5, 0x45, 0xc9, 0xa9, 0x12,
-	0xac, 0x60, 0x7d, 0x50, 0x9e, 0x92, 0x24, 0x17, 0x4b, 0x48, 0x65, 0x41, 0xaa, 0x10, 0x07, 0x17,
-	0x8b, 0x9b, 0xa7, 0x8f, 0xab, 0x00, 0x83, 0x10, 0x3b, 0x17, 0xb3, 0x8b, 0x67, 0x90, 0x00, 0xa3,
-	0x92, 0x1d, 0xc8, 0x09, 0x21, 0x45, 0xa9, 0xa9, 0x42, 0x22, 0x5c, 0xac, 0xfe, 0xe5, 0x79, 0xa9,
-	0x45, 0x60, 0x57, 0x70, 0x06, 0x41, 0x38, 0x42, 0x72, 0x5c, 0x2c, 0x1e, 0xa9, 0x89, 0x29, 0x60,
-	0x87, 0xa0, 0xda, 0x09, 0x16, 0x4f, 0x62, 0x03, 0x7b, 0xc7, 0x18, 0x10, 0x00, 0x00, 0xff, 0xff,
-	0x53, 0x36, 0x48, 0xd3, 0xe1, 0x00, 0x00, 0x00,
+	// 393 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x52, 0xc1, 0x6e, 0xd3, 0x40,
+	0x10, 0x65, 0xed, 0x4d, 0x62, 0x4f, 0x4b, 0x1b, 0xad, 0x10, 0x5a, 0x21, 0x14, 0xad, 0xd2, 0x8b,
+	0x4f, 0x39, 0x94, 0x2f, 0x40, 0xa4, 0x55, 0x2d, 0x92, 0x82, 0x36, 0xbe, 0x23, 0x3b, 0x1e, 0x6a,
+	0x2b, 0x4e, 0xd6, 0x5a, 0x6f, 0x04, 0xe5, 0x0b, 0xf8, 0x52, 0xbe, 0x03, 0xed, 0x18, 0x88, 0x81,
+	0x43, 0x6f, 0xef, 0xbd, 0x59, 0xcf, 0xbc, 0xf7, 0x64, 0xb8, 0xfc, 0x5c, 0x37, 0xf8, 0xc9, 0x59,
+	0xc4, 0x45, 0x6b, 0x8d, 0x33, 0x22, 0x68, 0x8b, 0xf9, 0x8f, 0x00, 0xc6, 0xb7, 0x9b, 0x7b, 0x53,
+	0xa2, 0xb8, 0x80, 0x20, 0x5d, 0x4a, 0xa6, 0x58, 0x72, 0xae, 0x83, 0x74, 0x29, 0x04, 0xf0, 0x8f,
+	0xb9, 0xab, 0x64, 0xa0, 0x58, 0x12, 0x6b, 0xc2, 0xe2, 0x0a, 0xb8, 0x7b, 0x6c, 0x51, 0x86, 0x8a,
+	0x25, 0x17, 0xd7, 0x97, 0x8b, 0xb6, 0x58, 0xf4, 0x5f, 0x2f, 0xb2, 0xc7, 0x16, 0x35, 0x0d, 0x85,
+	0x82, 0xd1, 0xaa, 0x3e, 0xec, 0x3a, 0xc9, 0x55, 0x98, 0x9c, 0x5d, 0xc3, 0xe9, 0x95, 0xee, 0x07,
+	0xe2, 0x25, 0x8c, 0x37, 0xe6, 0x68, 0xb7, 0x28, 0x47, 0xb4, 0xfc, 0x17, 0xf3, 0x27, 0x37, 0xf5,
+	0x37, 0x94, 0x63, 0xc5, 0x92, 0x50, 0x13, 0x16, 0xaf, 0x20, 0x7a, 0x57, 0xe1, 0x76, 0xd7, 0x1d,
+	0xf7, 0x72, 0x42, 0xaf, 0xff, 0x70, 0x31, 0x03, 0x58, 0xa3, 0xdd, 0x35, 0x78, 0x97, 0x77, 0x95,
+	0x8c, 0x68, 0x3a, 0x50, 0xfc, 0xbe, 0xb5, 0x29, 0x51, 0xc6, 0x8a, 0x25, 0xcf, 0x35, 0x61, 0x21,
+	0x61, 0xb2, 0x36, 0x65, 0x56, 0xef, 0x51, 0x02, 0x9d, 0xf9, 0x4d, 0xfd, 0xb6, 0x9b, 0xaf, 0xb8,
+	0x3d, 0xba, 0xbc, 0x68, 0x50, 0x9e, 0x29, 0x96, 0x44, 0x7a, 0xa0, 0x78, 0xd7, 0x59, 0x6e, 0x1f,
+	0xd0, 0xc9, 0xf3, 0xde, 0x75, 0xcf, 0xe6, 0x57, 0xc0, 0x7d, 0x7a, 0x11, 0x01, 0xbf, 0x4d, 0x57,
+	0x37, 0xd3, 0x67, 0x62, 0x02, 0xe1, 0x32, 0xd5, 0x53, 0xe6, 0xa5, 0x55, 0x7a, 0xff, 0x7e, 0x1a,
+	0xcc, 0xad, 0xef, 0x39, 0xb3, 0x88, 0xe2, 0x05, 0x8c, 0x3e, 0x7c, 0x39, 0xa0, 0xa5, 0xaa, 0x63,
+	0xdd, 0x13, 0x31, 0x03, 0x7e, 0x87, 0x79, 0x49, 0x6d, 0xff, 0xdd, 0x19, 0xe9, 0x3e, 0x8a, 0x36,
+	0xc6, 0x51, 0xf3, 0xb1, 0x26, 0x7c, 0x8a, 0xff, 0xb6, 0x79, 0x30, 0x92, 0x0f, 0xe3, 0x7b, 0x65,
+	0xfe, 0x9d, 0x41, 0xb4, 0x39, 0xe4, 0x6d, 0x57, 0x19, 0x27, 0x5e, 0x43, 0xec, 0x53, 0x76, 0x2e,
+	0xdf, 0xb7, 0x74, 0x3a, 0xd4, 0x27, 0xe1, 0x9f, 0x26, 0x83, 0xff, 0x9a, 0x9c, 0x01, 0xf7, 0xe6,
+	0x65, 0x38, 0xb4, 0xe7, 0x15, 0x4d, 0xfa, 0x53, 0x56, 0x8a, 0x31, 0xfd, 0x72, 0x6f, 0x7e, 0x0e,
+	0x00, 0xc4, 0x21, 0xb9, 0x2b, 0x85, 0x02, 0x00, 0x00,
 }
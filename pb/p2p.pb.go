@@ -20,6 +20,34 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+type Error_Code int32
+
+const (
+	Error_INTERNAL       Error_Code = 0
+	Error_UNKNOWN_METHOD Error_Code = 1
+	Error_NOT_FOUND      Error_Code = 2
+)
+
+var Error_Code_name = map[int32]string{
+	0: "INTERNAL",
+	1: "UNKNOWN_METHOD",
+	2: "NOT_FOUND",
+}
+
+var Error_Code_value = map[string]int32{
+	"INTERNAL":       0,
+	"UNKNOWN_METHOD": 1,
+	"NOT_FOUND":      2,
+}
+
+func (x Error_Code) String() string {
+	return proto.EnumName(Error_Code_name, int32(x))
+}
+
+func (Error_Code) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{1, 0}
+}
+
 type MessageData struct {
 	Message              string   `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -59,23 +87,76 @@ func (m *MessageData) GetMessage() string {
 	return ""
 }
 
+type Error struct {
+	Code                 Error_Code `protobuf:"varint,1,opt,name=code,proto3,enum=pb.Error_Code" json:"code,omitempty"`
+	Message              string     `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *Error) Reset()         { *m = Error{} }
+func (m *Error) String() string { return proto.CompactTextString(m) }
+func (*Error) ProtoMessage()    {}
+func (*Error) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{1}
+}
+
+func (m *Error) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Error.Unmarshal(m, b)
+}
+func (m *Error) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Error.Marshal(b, m, deterministic)
+}
+func (m *Error) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Error.Merge(m, src)
+}
+func (m *Error) XXX_Size() int {
+	return xxx_messageInfo_Error.Size(m)
+}
+func (m *Error) XXX_DiscardUnknown() {
+	xxx_messageInfo_Error.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Error proto.InternalMessageInfo
+
+func (m *Error) GetCode() Error_Code {
+	if m != nil {
+		return m.Code
+	}
+	return Error_INTERNAL
+}
+
+func (m *Error) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
 type Response struct {
 	PeerId    string `protobuf:"bytes,1,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
 	RequestId string `protobuf:"bytes,2,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
 	// Types that are valid to be assigned to Result:
 	//	*Response_Error
 	//	*Response_Fstree
-	Result               isResponse_Result `protobuf_oneof:"result"`
-	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
-	XXX_unrecognized     []byte            `json:"-"`
-	XXX_sizecache        int32             `json:"-"`
+	//	*Response_MerkleHash
+	//	*Response_Fsnode
+	Result isResponse_Result `protobuf_oneof:"result"`
+	// merkle_algo identifies the scheme of the merkle hashes in the result.
+	MerkleAlgo string `protobuf:"bytes,7,opt,name=merkle_algo,json=merkleAlgo,proto3" json:"merkle_algo,omitempty"`
+	// owner is the name of the device serving the result.
+	Owner                string   `protobuf:"bytes,8,opt,name=owner,proto3" json:"owner,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *Response) Reset()         { *m = Response{} }
 func (m *Response) String() string { return proto.CompactTextString(m) }
 func (*Response) ProtoMessage()    {}
 func (*Response) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e7fdddb109e6467a, []int{1}
+	return fileDescriptor_e7fdddb109e6467a, []int{2}
 }
 
 func (m *Response) XXX_Unmarshal(b []byte) error {
@@ -115,17 +196,29 @@ type isResponse_Result interface {
 }
 
 type Response_Error struct {
-	Error string `protobuf:"bytes,3,opt,name=error,proto3,oneof"`
+	Error *Error `protobuf:"bytes,9,opt,name=error,proto3,oneof"`
 }
 
 type Response_Fstree struct {
 	Fstree *FSTree `protobuf:"bytes,4,opt,name=fstree,proto3,oneof"`
 }
 
+type Response_MerkleHash struct {
+	MerkleHash string `protobuf:"bytes,5,opt,name=merkle_hash,json=merkleHash,proto3,oneof"`
+}
+
+type Response_Fsnode struct {
+	Fsnode *FSNode `protobuf:"bytes,6,opt,name=fsnode,proto3,oneof"`
+}
+
 func (*Response_Error) isResponse_Result() {}
 
 func (*Response_Fstree) isResponse_Result() {}
 
+func (*Response_MerkleHash) isResponse_Result() {}
+
+func (*Response_Fsnode) isResponse_Result() {}
+
 func (m *Response) GetResult() isResponse_Result {
 	if m != nil {
 		return m.Result
@@ -133,11 +226,11 @@ func (m *Response) GetResult() isResponse_Result {
 	return nil
 }
 
-func (m *Response) GetError() string {
+func (m *Response) GetError() *Error {
 	if x, ok := m.GetResult().(*Response_Error); ok {
 		return x.Error
 	}
-	return ""
+	return nil
 }
 
 func (m *Response) GetFstree() *FSTree {
@@ -147,18 +240,54 @@ func (m *Response) GetFstree() *FSTree {
 	return nil
 }
 
+func (m *Response) GetMerkleHash() string {
+	if x, ok := m.GetResult().(*Response_MerkleHash); ok {
+		return x.MerkleHash
+	}
+	return ""
+}
+
+func (m *Response) GetFsnode() *FSNode {
+	if x, ok := m.GetResult().(*Response_Fsnode); ok {
+		return x.Fsnode
+	}
+	return nil
+}
+
+func (m *Response) GetMerkleAlgo() string {
+	if m != nil {
+		return m.MerkleAlgo
+	}
+	return ""
+}
+
+func (m *Response) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*Response) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
 		(*Response_Error)(nil),
 		(*Response_Fstree)(nil),
+		(*Response_MerkleHash)(nil),
+		(*Response_Fsnode)(nil),
 	}
 }
 
 type Request struct {
-	PeerId               string   `protobuf:"bytes,1,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
-	RequestId            string   `protobuf:"bytes,2,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
-	Method               string   `protobuf:"bytes,3,opt,name=method,proto3" json:"method,omitempty"`
+	PeerId    string `protobuf:"bytes,1,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
+	RequestId string `protobuf:"bytes,2,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Method    string `protobuf:"bytes,3,opt,name=method,proto3" json:"method,omitempty"`
+	// path and id locate the requested node, the path relative to the root
+	// is used when both are set.
+	Path string `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`
+	Id   []byte `protobuf:"bytes,5,opt,name=id,proto3" json:"id,omitempty"`
+	// depth is the number of levels of links returned under the node, 0 for all.
+	Depth                int32    `protobuf:"varint,6,opt,name=depth,proto3" json:"depth,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -168,7 +297,7 @@ func (m *Request) Reset()         { *m = Request{} }
 func (m *Request) String() string { return proto.CompactTextString(m) }
 func (*Request) ProtoMessage()    {}
 func (*Request) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e7fdddb109e6467a, []int{2}
+	return fileDescriptor_e7fdddb109e6467a, []int{3}
 }
 
 func (m *Request) XXX_Unmarshal(b []byte) error {
@@ -210,8 +339,31 @@ func (m *Request) GetMethod() string {
 	return ""
 }
 
+func (m *Request) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *Request) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Request) GetDepth() int32 {
+	if m != nil {
+		return m.Depth
+	}
+	return 0
+}
+
 func init() {
+	proto.RegisterEnum("pb.Error_Code", Error_Code_name, Error_Code_value)
 	proto.RegisterType((*MessageData)(nil), "pb.MessageData")
+	proto.RegisterType((*Error)(nil), "pb.Error")
 	proto.RegisterType((*Response)(nil), "pb.Response")
 	proto.RegisterType((*Request)(nil), "pb.Request")
 }
@@ -219,19 +371,31 @@ func init() {
 func init() { proto.RegisterFile("p2p.proto", fileDescriptor_e7fdddb109e6467a) }
 
 var fileDescriptor_e7fdddb109e6467a = []byte{
-	// 216 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x90, 0xc1, 0x4a, 0x03, 0x31,
-	0x14, 0x45, 0x9b, 0xaa, 0x99, 0xce, 0xeb, 0x42, 0xc8, 0x42, 0x83, 0x20, 0x94, 0x41, 0xb0, 0xab,
-	0x59, 0xd4, 0x3f, 0x10, 0x91, 0x76, 0xe1, 0x26, 0xba, 0x71, 0x55, 0x66, 0xc8, 0xad, 0x16, 0x3a,
-	0x26, 0xbe, 0x64, 0x7e, 0xc2, 0xaf, 0x96, 0x4c, 0xe2, 0x17, 0xb8, 0x3c, 0xf7, 0x84, 0x70, 0x78,
-	0x54, 0xfb, 0x8d, 0x6f, 0x3d, 0xbb, 0xe8, 0xd4, 0xdc, 0xf7, 0x37, 0x97, 0x87, 0xe3, 0x09, 0xfb,
-	0xc8, 0x40, 0x1e, 0x9b, 0x7b, 0x5a, 0xbe, 0x20, 0x84, 0xee, 0x03, 0x4f, 0x5d, 0xec, 0x94, 0xa6,
-	0x6a, 0xc8, 0xa8, 0xc5, 0x4a, 0xac, 0x6b, 0xf3, 0x87, 0xcd, 0x8f, 0xa0, 0x85, 0x41, 0xf0, 0xee,
-	0x2b, 0x40, 0x5d, 0x53, 0xe5, 0x01, 0xde, 0x1f, 0x6d, 0x79, 0x26, 0x13, 0xee, 0xac, 0xba, 0x25,
-	0x62, 0x7c, 0x8f, 0x08, 0x31, 0xb9, 0xf9, 0xe4, 0xea, 0xb2, 0xec, 0xac, 0xba, 0xa2, 0x0b, 0x30,
-	0x3b, 0xd6, 0x67, 0xc9, 0x6c, 0x67, 0x26, 0xa3, 0xba, 0x23, 0x79, 0x08, 0xa9, 0x4a, 0x9f, 0xaf,
-	0xc4, 0x7a, 0xb9, 0xa1, 0xd6, 0xf7, 0xed, 0xf3, 0xeb, 0x1b, 0x03, 0xdb, 0x99, 0x29, 0xee, 0x71,
-	0x41, 0x92, 0x11, 0xc6, 0x53, 0x6c, 0xde, 0xa9, 0x32, 0xf9, 0xd3, 0x7f, 0xa4, 0xc8, 0x01, 0xf1,
-	0xd3, 0xd9, 0xdc, 0x62, 0x0a, 0xf5, 0x72, 0xba, 0xcb, 0xc3, 0x6f, 0x00, 0x00, 0x00, 0xff, 0xff,
-	0x39, 0x92, 0xd1, 0x4c, 0x39, 0x01, 0x00, 0x00,
+	// 403 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x92, 0x41, 0x6f, 0xd3, 0x40,
+	0x10, 0x85, 0x63, 0xd7, 0x76, 0xec, 0x49, 0x09, 0xd1, 0x08, 0x81, 0x85, 0x84, 0x68, 0x2d, 0x24,
+	0x7a, 0xca, 0x21, 0x1c, 0x38, 0x17, 0x92, 0xca, 0x01, 0xea, 0x48, 0x4b, 0x2a, 0x8e, 0x96, 0xc3,
+	0x4e, 0x63, 0x0b, 0x27, 0xbb, 0xec, 0x6e, 0xc5, 0x8d, 0x3f, 0xc1, 0x91, 0x3f, 0x8b, 0x76, 0xd7,
+	0x54, 0xe5, 0xca, 0xcd, 0x6f, 0xbe, 0xf1, 0x9b, 0x37, 0xa3, 0x85, 0x4c, 0x2e, 0xe4, 0x5c, 0x2a,
+	0x61, 0x04, 0x86, 0x72, 0xf7, 0xfc, 0xf1, 0x6d, 0xd7, 0x53, 0x6d, 0x14, 0x91, 0x2f, 0x16, 0xaf,
+	0x61, 0x72, 0x4d, 0x5a, 0x37, 0x7b, 0x5a, 0x36, 0xa6, 0xc1, 0x1c, 0xc6, 0x07, 0x2f, 0xf3, 0xe0,
+	0x2c, 0xb8, 0xc8, 0xd8, 0x5f, 0x59, 0xfc, 0x84, 0x78, 0xa5, 0x94, 0x50, 0x58, 0x40, 0xf4, 0x55,
+	0x70, 0xcf, 0xa7, 0x8b, 0xe9, 0x5c, 0xee, 0xe6, 0x0e, 0xcc, 0xdf, 0x0b, 0x4e, 0xcc, 0xb1, 0x87,
+	0x36, 0xe1, 0xbf, 0x36, 0x6f, 0x21, 0xb2, 0x7d, 0x78, 0x0a, 0xe9, 0xba, 0xda, 0xae, 0x58, 0x75,
+	0xf9, 0x69, 0x36, 0x42, 0x84, 0xe9, 0x4d, 0xf5, 0xb1, 0xda, 0x7c, 0xa9, 0xea, 0xeb, 0xd5, 0xb6,
+	0xdc, 0x2c, 0x67, 0x01, 0x3e, 0x82, 0xac, 0xda, 0x6c, 0xeb, 0xab, 0xcd, 0x4d, 0xb5, 0x9c, 0x85,
+	0xc5, 0xef, 0x10, 0x52, 0x46, 0x5a, 0x8a, 0xa3, 0x26, 0x7c, 0x06, 0x63, 0x49, 0xa4, 0xea, 0x8e,
+	0x0f, 0x31, 0x13, 0x2b, 0xd7, 0x1c, 0x5f, 0x00, 0x28, 0xfa, 0x7e, 0x47, 0xda, 0x58, 0xe6, 0x67,
+	0x67, 0x43, 0x65, 0xcd, 0xf1, 0x1c, 0x62, 0xb2, 0x59, 0xf3, 0xec, 0x2c, 0xb8, 0x98, 0x2c, 0xb2,
+	0xfb, 0xf0, 0xe5, 0x88, 0x79, 0x82, 0xaf, 0x20, 0xb9, 0xd5, 0xf6, 0x40, 0x79, 0xe4, 0x7a, 0xc0,
+	0xf6, 0x5c, 0x7d, 0xde, 0x2a, 0xa2, 0x72, 0xc4, 0x06, 0x86, 0xe7, 0x30, 0x39, 0x90, 0xfa, 0xd6,
+	0x53, 0xdd, 0x36, 0xba, 0xcd, 0x63, 0x3b, 0xa8, 0x1c, 0x31, 0xf0, 0xc5, 0xb2, 0xd1, 0xad, 0x37,
+	0x3a, 0xda, 0x4b, 0x25, 0x0f, 0x8d, 0x2a, 0xc1, 0x07, 0x23, 0xcb, 0xf0, 0xe5, 0xbd, 0x51, 0xd3,
+	0xef, 0x45, 0x3e, 0x76, 0x89, 0x07, 0x9b, 0xcb, 0x7e, 0x2f, 0xf0, 0x09, 0xc4, 0xe2, 0xc7, 0x91,
+	0x54, 0x9e, 0x3a, 0xe4, 0xc5, 0xbb, 0x14, 0x12, 0x45, 0xfa, 0xae, 0x37, 0x1f, 0xa2, 0xf4, 0x64,
+	0x16, 0x15, 0xbf, 0x02, 0x18, 0x33, 0xbf, 0xe6, 0x7f, 0x1f, 0xe7, 0x29, 0x24, 0x07, 0x32, 0xad,
+	0xe0, 0xf9, 0x89, 0xff, 0xcd, 0x2b, 0x44, 0x88, 0x64, 0x63, 0x5a, 0x77, 0x8f, 0x8c, 0xb9, 0x6f,
+	0x9c, 0x42, 0xd8, 0x71, 0xb7, 0xf6, 0x29, 0x0b, 0x3b, 0x6e, 0x53, 0x72, 0x92, 0xa6, 0x75, 0xbb,
+	0xc6, 0xcc, 0x8b, 0x5d, 0xe2, 0xde, 0xd8, 0x9b, 0x3f, 0x03, 0x00, 0x4a, 0xd7, 0x11, 0x70, 0x85,
+	0x02, 0x00, 0x00,
 }
@@ -0,0 +1,26 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/orbit-drive/orbit-drive/vtree"
+)
+
+// Conflicts prints the conflicts resolved while pulling the peer trees
+// with the version kept and the conflict copy if any.
+func Conflicts() error {
+	conflicts, err := vtree.Conflicts()
+	if err != nil {
+		return err
+	}
+	for _, c := range conflicts {
+		timestamp := time.Unix(0, c.Timestamp)
+		fmt.Printf("%s %-6s %s %s", timestamp.Format(time.RFC3339), c.Kept, c.Device, c.Path)
+		if c.Copy != "" {
+			fmt.Printf(" -> %s", c.Copy)
+		}
+		fmt.Println()
+	}
+	return nil
+}
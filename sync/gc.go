@@ -0,0 +1,64 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/orbit-drive/orbit-drive/config"
+	"github.com/orbit-drive/orbit-drive/vtree"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// gcInterval is the duration between garbage collections while syncing.
+	gcInterval = 24 * time.Hour
+)
+
+func retentionPolicy(c *config.Config) vtree.RetentionPolicy {
+	day := 24 * time.Hour
+	return vtree.RetentionPolicy{
+		KeepLast:    c.Retention.KeepLast,
+		KeepDaily:   time.Duration(c.Retention.KeepDailyDays) * day,
+		KeepDeleted: time.Duration(c.Retention.KeepDeletedDays) * day,
+	}
+}
+
+func runGC(c *config.Config, vt *vtree.VTree, dryRun bool) (*vtree.GCReport, error) {
+	report, err := vt.GC(retentionPolicy(c), time.Now(), dryRun)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"snapshots": len(report.Pruned),
+		"unpinned":  len(report.Unpinned),
+		"bytes":     report.Bytes,
+		"dry-run":   dryRun,
+	}).Info("Garbage collection done!")
+	return report, nil
+}
+
+// GC deletes the snapshots not retained by the config retention and unpins
+// the contents no longer referenced, then prints the report.
+func GC(c *config.Config, dryRun bool) error {
+	store, err := initContentStore(c)
+	if err != nil {
+		return err
+	}
+	vt, err := vtree.LoadVTree(c.Root, store)
+	if err != nil {
+		return err
+	}
+	report, err := runGC(c, vt, dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range report.Pruned {
+		fmt.Printf("snapshot %s\n", hash)
+	}
+	for _, src := range report.Unpinned {
+		fmt.Printf("unpin    %s\n", src)
+	}
+	fmt.Printf("%d snapshots, %d contents, %d bytes reclaimed\n", len(report.Pruned), len(report.Unpinned), report.Bytes)
+	return nil
+}
@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/orbit-drive/orbit-drive/config"
+	"github.com/orbit-drive/orbit-drive/vtree"
+)
+
+// History prints the saved snapshots, or the versions of the file at path
+// if provided, or the changes between the from and to snapshots if provided.
+func History(c *config.Config, path, from, to string) error {
+	switch {
+	case path != "":
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(c.Root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return vtree.ErrNotInRoot
+		}
+		versions, err := vtree.FileVersions(rel)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			fmt.Printf("%s %s %s %d\n", v.Timestamp.Format(time.RFC3339), v.MerkleHash, v.Src, v.Size)
+		}
+	case from != "" && to != "":
+		fromSnapshot, err := vtree.FindSnapshot(from)
+		if err != nil {
+			return err
+		}
+		toSnapshot, err := vtree.FindSnapshot(to)
+		if err != nil {
+			return err
+		}
+		for _, state := range vtree.DiffSnapshots(fromSnapshot, toSnapshot) {
+			if state.Op == vtree.MovedOp {
+				fmt.Printf("%-9s %s -> %s\n", state.Op, state.OldPath, state.Path)
+				continue
+			}
+			fmt.Printf("%-9s %s\n", state.Op, state.Path)
+		}
+	default:
+		snapshots, err := vtree.Snapshots()
+		if err != nil {
+			return err
+		}
+		for _, snapshot := range snapshots {
+			timestamp := time.Unix(0, snapshot.GetTimestamp())
+			fmt.Printf("%s %s\n", timestamp.Format(time.RFC3339), snapshot.GetMerkleHash())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package sync
+
+import (
+	"path/filepath"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/orbit-drive/orbit-drive/config"
+	"github.com/orbit-drive/orbit-drive/p2p"
+	"github.com/orbit-drive/orbit-drive/vtree"
+	"github.com/orbit-drive/orbit-drive/watcher"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// pullInterval is the duration between two pulls of the peer trees.
+	pullInterval = 30 * time.Second
+)
+
+// pullLoop pulls the peer trees every pullInterval until done is closed,
+// the number of changes applied by each pull is sent to pulled.
+func pullLoop(c *config.Config, vt *vtree.VTree, w *watcher.Watcher, pulled chan<- int, done <-chan bool) {
+	ticker := time.NewTicker(pullInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n := pull(c, vt, w)
+			if n == 0 {
+				continue
+			}
+			select {
+			case pulled <- n:
+			case <-done:
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// pull applies the changes of each connected peer tree to the root,
+// returns the number of changes applied.
+func pull(c *config.Config, vt *vtree.VTree, w *watcher.Watcher) int {
+	applied := 0
+	for _, pid := range p2p.Peers() {
+		n, err := pullPeer(c, vt, w, pid)
+		if err != nil {
+			log.WithField("peer-id", pid.Pretty()).Warn(err)
+		}
+		applied += n
+	}
+	return applied
+}
+
+// pullPeer fetches the tree of the peer and merges it with the vtree, the
+// remote changes since the last sync are written to disk with the watcher
+// events suppressed so they are not synced back as local changes. The paths
+// changed on both sides are resolved with the configured conflict policy.
+// The peer tree is saved as the base of the next merge once all its changes
+// are applied, the failed changes are retried on the next pull.
+func pullPeer(c *config.Config, vt *vtree.VTree, w *watcher.Watcher, pid peer.ID) (int, error) {
+	local := vt.ToProto()
+	remote, err := p2p.FetchTree(pid, local)
+	if err != nil {
+		return 0, err
+	}
+	base, err := vtree.LoadBase(pid.Pretty())
+	if err != nil {
+		return 0, err
+	}
+
+	changes, conflicts := vtree.Merge(base, local, remote)
+	applied, failed := applyChanges(vt, w, pid, changes)
+
+	device := remote.GetOwner()
+	if device == "" {
+		device = pid.Pretty()
+	}
+	// Both devices keep the version of the greatest peer id at the path.
+	remoteWins := pid.Pretty() > p2p.LocalID().Pretty()
+	for _, conflict := range vt.Resolve(conflicts, c.ConflictPolicy, device, remoteWins, time.Now()) {
+		n, f := applyChanges(vt, w, pid, conflict.Changes)
+		applied, failed = applied+n, failed+f
+		if f > 0 {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"peer-id": pid.Pretty(),
+			"path":    conflict.Path,
+			"kept":    conflict.Kept,
+		}).Warn("Path changed locally and remotely, conflict resolved")
+		if err := vtree.SaveConflict(conflict); err != nil {
+			return applied, err
+		}
+		vt.PushToState(conflict.Path, vtree.ConflictOp)
+	}
+	if failed > 0 {
+		log.WithField("failed", failed).Warn("Remote changes not applied, retrying on next pull")
+		return applied, nil
+	}
+	return applied, vtree.SaveBase(pid.Pretty(), remote)
+}
+
+// applyChanges applies the remote changes of the peer, returns the number
+// of changes applied and failed.
+func applyChanges(vt *vtree.VTree, w *watcher.Watcher, pid peer.ID, changes []vtree.Change) (int, int) {
+	applied, failed := 0, 0
+	for _, c := range changes {
+		err := applyChange(vt, w, c)
+		if err == vtree.ErrIgnored {
+			log.WithField("path", c.Path).Debug("Remote change ignored locally")
+			continue
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"peer-id":   pid.Pretty(),
+				"path":      c.Path,
+				"operation": c.Op,
+			}).Warn(err)
+			failed++
+			continue
+		}
+		log.WithFields(log.Fields{
+			"peer-id":   pid.Pretty(),
+			"path":      c.Path,
+			"operation": c.Op,
+		}).Info("Remote change applied!")
+		applied++
+	}
+	return applied, failed
+}
+
+// applyChange applies the remote change with the watcher events of its
+// paths suppressed.
+func applyChange(vt *vtree.VTree, w *watcher.Watcher, c vtree.Change) error {
+	paths := []string{filepath.Join(vt.RootPath(), c.Path)}
+	if c.OldPath != "" {
+		paths = append(paths, filepath.Join(vt.RootPath(), c.OldPath))
+	}
+	return w.Write(func() ([]string, []string, error) {
+		return vt.Apply(c)
+	}, paths...)
+}
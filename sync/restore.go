@@ -0,0 +1,70 @@
+package sync
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/orbit-drive/orbit-drive/config"
+	"github.com/orbit-drive/orbit-drive/vtree"
+)
+
+// findVTree returns the vtree of the snapshot matching the merkle hash,
+// the current vtree is returned if no hash is provided.
+func findVTree(vt *vtree.VTree, hash string) (*vtree.VTree, error) {
+	if hash == "" || hash == vt.MerkleHash() {
+		return vt, nil
+	}
+	snapshot, err := vtree.FindSnapshot(hash)
+	if err != nil {
+		return nil, err
+	}
+	return vtree.NewVTreeFromSnapshot(snapshot, vt.Store()), nil
+}
+
+// Restore writes the path of the saved vtree, or of the vtree matching the
+// merkle hash, to the target dir keeping its path relative to the root.
+// Files modified since they were synced are only overwritten if forced.
+func Restore(c *config.Config, path, target, hash string, dryRun, force bool) error {
+	store, err := initContentStore(c)
+	if err != nil {
+		return err
+	}
+	current, err := vtree.LoadVTree(c.Root, store)
+	if err != nil {
+		return err
+	}
+	vt, err := findVTree(current, hash)
+	if err != nil {
+		return err
+	}
+
+	if path, err = filepath.Abs(path); err != nil {
+		return err
+	}
+	if target, err = filepath.Abs(target); err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(c.Root, path)
+	if err != nil {
+		return err
+	}
+	vn, err := vt.Find(path)
+	if err != nil {
+		return err
+	}
+
+	r := &vtree.Restorer{
+		DryRun:  dryRun,
+		Force:   force,
+		Current: current,
+	}
+	r.Restore(vn, filepath.Join(target, rel))
+	for _, result := range r.Results {
+		if result.Err != nil {
+			fmt.Printf("%-14s %s (%s)\n", result.Status, result.Path, result.Err)
+			continue
+		}
+		fmt.Printf("%-14s %s\n", result.Status, result.Path)
+	}
+	return r.Err()
+}
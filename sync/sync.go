@@ -3,40 +3,97 @@ package sync
 import (
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
-	"github.com/gogo/protobuf/proto"
 	"github.com/orbit-drive/orbit-drive/config"
 	"github.com/orbit-drive/orbit-drive/db"
 	"github.com/orbit-drive/orbit-drive/ipfs"
 	"github.com/orbit-drive/orbit-drive/p2p"
 	"github.com/orbit-drive/orbit-drive/sys"
+	"github.com/orbit-drive/orbit-drive/utils"
 	"github.com/orbit-drive/orbit-drive/vtree"
 	"github.com/orbit-drive/orbit-drive/watcher"
 	log "github.com/sirupsen/logrus"
 )
 
-func initVTree(c *config.Config) (*vtree.VTree, error) {
+const (
+	// settleDuration is the quiet period after the last state change
+	// before the vtree is saved as a snapshot.
+	settleDuration = 5 * time.Second
+)
+
+// initVTree loads the saved vtree or builds a new one, returns the
+// vtree and the state changes made while orbit drive was not running.
+func initVTree(c *config.Config, store ipfs.ContentStore) (*vtree.VTree, []vtree.State, error) {
 	log.Info("Initializing vtree...")
 
-	s, err := db.GetSources()
+	var states []vtree.State
+	vt, err := vtree.LoadVTree(c.Root, store)
+	switch err {
+	case nil:
+		vt.SetIgnorePatterns(c.Ignore)
+		vt.SetSymlinkPolicy(c.Symlinks)
+		vt.SetOwner(c.DeviceName)
+		log.Info("Reconciling saved vtree with disk...")
+		if states, err = vt.Reconcile(); err != nil {
+			return nil, nil, err
+		}
+		log.WithField("changes", len(states)).Info("Offline changes detected!")
+	case vtree.ErrVTreeNotFound:
+		if vt, err = buildVTree(c, store); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, err
+	}
+
+	if err := vt.Save(); err != nil {
+		return nil, nil, err
+	}
+	log.Info("VTree successfully initialized!")
+	return vt, states, nil
+}
+
+func buildVTree(c *config.Config, store ipfs.ContentStore) (*vtree.VTree, error) {
+	log.Info("No saved vtree found, building vtree from disk...")
+
+	if err := vtree.MigrateSources(c.Root); err != nil {
+		return nil, err
+	}
+	s, err := db.GetSources(vtree.ReservedPrefixes()...)
 	if err != nil {
 		return nil, err
 	}
 
-	vt := vtree.NewVTree(c.Root)
+	vt := vtree.NewVTree(c.Root, store)
+	vt.SetIgnorePatterns(c.Ignore)
+	vt.SetSymlinkPolicy(c.Symlinks)
+	vt.SetOwner(c.DeviceName)
 	if err := vt.Build(s); err != nil {
 		return nil, err
 	}
 	s.Dump()
-	log.Info("VTree successfully initialized!")
 	return vt, nil
 }
 
+func initContentStore(c *config.Config) (ipfs.ContentStore, error) {
+	if c.ContentStore == config.LocalStore {
+		storeDir := filepath.Join(utils.GetConfigDir(), "store")
+		log.WithField("dir", storeDir).Info("Initializing local store...")
+		return ipfs.NewLocalStore(storeDir)
+	}
+	log.WithField("node-addr", c.NodeAddr).Info("Initializing ipfs shell...")
+	return ipfs.NewShellStore(c.NodeAddr), nil
+}
+
 func initWatcher(c *config.Config, vt *vtree.VTree) (*watcher.Watcher, error) {
 	log.Info("Initializing watcher...")
 
-	w, err := watcher.NewWatcher(c.Root)
+	quietWindow := time.Duration(c.QuietWindowMs) * time.Millisecond
+	pollInterval := time.Duration(c.PollIntervalMs) * time.Millisecond
+	w, err := watcher.NewWatcher(c.Root, c.WatcherBackend, quietWindow, pollInterval)
 	if err != nil {
 		return nil, err
 	}
@@ -49,10 +106,13 @@ func initWatcher(c *config.Config, vt *vtree.VTree) (*watcher.Watcher, error) {
 	return w, nil
 }
 
-func initP2P(c *config.Config) {
+// initP2P connects to the peers and serves them the vtree, the sync goes on
+// locally if the connection fails.
+func initP2P(c *config.Config, vt *vtree.VTree) {
 	log.Info("Initializing p2p connection to bootstrap nodes...")
-	if err := p2p.InitConn(c.P2PPort, c.SecretPhrase); err != nil {
-		sys.Fatal(err.Error())
+	if err := p2p.InitConn(c.P2PPort, c.SecretPhrase, vt); err != nil {
+		sys.Alert(err.Error())
+		return
 	}
 	log.Info("p2p network connections successfully established!")
 }
@@ -62,27 +122,60 @@ func Run(c *config.Config) {
 	sys.Notify("Starting file sync!")
 	defer sys.Alert("Stopping file sync!")
 
-	log.WithField("node-addr", c.NodeAddr).Info("Initializing ipfs shell...")
-	ipfs.InitShell(c.NodeAddr)
-
-	// TODO: remove -> moving to web hub ???
-	// go initP2P(c)
+	store, err := initContentStore(c)
+	if err != nil {
+		sys.Fatal(err.Error())
+	}
 
-	vt, err := initVTree(c)
+	vt, offlineStates, err := initVTree(c, store)
 	if err != nil {
 		sys.Fatal(err.Error())
 	}
 	log.WithField("hash", vt.MerkleHash()).Info("VTree loaded merkle hash")
 
+	go initP2P(c, vt)
+
 	watcher, err := initWatcher(c, vt)
 	if err != nil {
 		sys.Fatal(err.Error())
 	}
 	defer watcher.Stop()
 
+	// The peer trees are pulled aside so the watcher states are still
+	// consumed while the remote changes are written.
+	pulled := make(chan int)
+	done := make(chan bool)
+	defer close(done)
+	go pullLoop(c, vt, watcher, pulled, done)
+
 	close := make(chan os.Signal, 2)
 	signal.Notify(close, os.Interrupt, syscall.SIGTERM)
 
+	if _, err := vt.SaveSnapshot(); err != nil {
+		sys.Alert(err.Error())
+	}
+
+	// Offline changes go through the same state pipeline as live changes.
+	go vt.PushStates(offlineStates)
+
+	gc := time.NewTicker(gcInterval)
+	defer gc.Stop()
+
+	// The vtree is saved once the changes settle or on close, not on
+	// every change.
+	var settled <-chan time.Time
+	unsaved := false
+	save := func() {
+		if !unsaved {
+			return
+		}
+		if err := vt.Save(); err != nil {
+			sys.Alert(err.Error())
+			return
+		}
+		unsaved = false
+		log.WithField("hash", vt.MerkleHash()).Info("vtree successfully saved!")
+	}
 	for {
 		select {
 		case state := <-vt.StateChanges():
@@ -90,15 +183,30 @@ func Run(c *config.Config) {
 				"path":      state.Path,
 				"operation": state.Op,
 			}).Info("vtree state change detected!")
-
-			vtPb := vt.ToProto()
-			parsedPb, err := proto.Marshal(vtPb)
+			if state.Op == vtree.ConflictOp {
+				sys.Notify("Conflict resolved on ", state.Path, ", run orbit-drive conflicts for details.")
+			}
+			unsaved = true
+			settled = time.After(settleDuration)
+		case <-settled:
+			settled = nil
+			save()
+			snapshot, err := vt.SaveSnapshot()
 			if err != nil {
 				sys.Alert(err.Error())
+				continue
+			}
+			log.WithField("hash", snapshot.GetMerkleHash()).Info("vtree snapshot saved!")
+		case n := <-pulled:
+			log.WithField("changes", n).Info("Remote changes applied!")
+			unsaved = true
+			settled = time.After(settleDuration)
+		case <-gc.C:
+			if _, err := runGC(c, vt, false); err != nil {
+				sys.Alert(err.Error())
 			}
-			log.WithField("byte-data", parsedPb).Info("vtree successfully parsed to pb!")
-			p2p.GetMerkleHash()
 		case <-close:
+			save()
 			return
 		}
 	}
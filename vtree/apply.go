@@ -0,0 +1,217 @@
+package vtree
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/utils"
+)
+
+// Apply writes a change received from a peer to the disk and updates the
+// vtree, the files are downloaded from the content store by their source.
+// The paths modified on disk since they were synced are left untouched and
+// ErrLocallyModified is returned. No state is pushed since the change is not
+// made locally. Returns the dir paths added and removed.
+// Paths outside the root return ErrNotInRoot before anything is written,
+// paths ignored locally return ErrIgnored and the links ignored locally are
+// skipped.
+func (vt *VTree) Apply(c Change) ([]string, []string, error) {
+	if err := vt.checkPaths(c); err != nil {
+		return nil, nil, err
+	}
+	if c.Op != RemovedOp && vt.isIgnoredNode(c.Path, c.To) {
+		return nil, nil, ErrIgnored
+	}
+	abspath := filepath.Join(vt.RootPath(), c.Path)
+	switch c.Op {
+	case RemovedOp:
+		return vt.applyRemove(abspath)
+	case MovedOp:
+		return vt.applyMove(filepath.Join(vt.RootPath(), c.OldPath), abspath)
+	}
+	return vt.applyWrite(c, abspath)
+}
+
+// checkPaths returns ErrNotInRoot if a path of the change is not relative
+// to the root or a link of its node is not directly under the node path.
+func (vt *VTree) checkPaths(c Change) error {
+	if err := vt.checkPath(c.Path); err != nil {
+		return err
+	}
+	if c.Op == MovedOp {
+		if err := vt.checkPath(c.OldPath); err != nil {
+			return err
+		}
+	}
+	return checkLinkPaths(c.To)
+}
+
+// checkPath returns ErrNotInRoot if the path is absolute or leaves the root.
+func (vt *VTree) checkPath(p string) error {
+	if filepath.IsAbs(p) {
+		return ErrNotInRoot
+	}
+	_, err := vt.Rel(filepath.Join(vt.RootPath(), p))
+	return err
+}
+
+// checkLinkPaths returns ErrNotInRoot if a link path is not the path of the
+// node joined with the link name, recursively.
+func checkLinkPaths(n *pb.FSNode) error {
+	for _, link := range n.GetLinks() {
+		p := link.GetPath()
+		if p != filepath.Join(n.GetPath(), filepath.Base(p)) {
+			return ErrNotInRoot
+		}
+		if err := checkLinkPaths(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isIgnoredNode returns true if the path of the node is ignored locally.
+func (vt *VTree) isIgnoredNode(p string, n *pb.FSNode) bool {
+	vt.RLock()
+	defer vt.RUnlock()
+	return vt.Head.settings.ignored(filepath.Join(vt.RootPath(), p), n.GetType() == pb.FSNode_DIR)
+}
+
+// withoutIgnored returns a copy of the node without the links ignored
+// locally, the protobuf of the change is left untouched.
+func (vt *VTree) withoutIgnored(n *pb.FSNode) *pb.FSNode {
+	c := truncateProto(n, 0)
+	for _, link := range n.GetLinks() {
+		if vt.Head.settings.ignored(filepath.Join(vt.RootPath(), link.GetPath()), link.GetType() == pb.FSNode_DIR) {
+			continue
+		}
+		c.Links = append(c.Links, vt.withoutIgnored(link))
+	}
+	return c
+}
+
+// applyWrite downloads the node of the change to abspath and links it
+// in place of the vnode at the same path.
+func (vt *VTree) applyWrite(c Change, abspath string) ([]string, []string, error) {
+	vt.RLock()
+	to := vt.withoutIgnored(c.To)
+	vt.RUnlock()
+	vn := NewVNodeFromProto(to, vt.Store())
+	// Files are only overwritten if they match the current vtree.
+	r := &Restorer{Current: vt}
+	r.Restore(vn, abspath)
+	if err := r.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	vt.Lock()
+	defer vt.Unlock()
+	parent, err := vt.Head.FindChildAt(filepath.Dir(c.Path))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !parent.IsDir() {
+		return nil, nil, ErrNotADir
+	}
+	removedDirPaths := []string{}
+	if old, err := parent.UnlinkChild(c.Path); err == nil {
+		removedDirPaths = old.AllDirPaths()
+		if err := db.BatchDelete(old.AllIDs()); err != nil {
+			return nil, nil, err
+		}
+	}
+	// The ids are regenerated from the local parent.
+	vn.Relocate(parent, c.Path)
+	vn.setSettings(parent.settings)
+	parent.LinkChild(vn)
+	return vn.AllDirPaths(), removedDirPaths, vn.AllSources().Save()
+}
+
+// applyRemove deletes abspath from the disk and the vtree.
+func (vt *VTree) applyRemove(abspath string) ([]string, []string, error) {
+	if err := vt.checkSynced(abspath); err != nil {
+		return nil, nil, err
+	}
+	if err := os.RemoveAll(abspath); err != nil {
+		return nil, nil, err
+	}
+	vt.Lock()
+	defer vt.Unlock()
+	_, dirPaths, err := vt.remove(abspath)
+	return []string{}, dirPaths, err
+}
+
+// applyMove renames oldPath to newPath on the disk and in the vtree.
+func (vt *VTree) applyMove(oldPath, newPath string) ([]string, []string, error) {
+	if err := vt.checkSynced(oldPath); err != nil {
+		return nil, nil, err
+	}
+	if utils.PathExists(newPath) {
+		return nil, nil, ErrLocallyModified
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), os.ModePerm); err != nil {
+		return nil, nil, err
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return nil, nil, err
+	}
+	vt.Lock()
+	defer vt.Unlock()
+	oldDirPaths, newDirPaths, err := vt.move(oldPath, newPath)
+	return newDirPaths, oldDirPaths, err
+}
+
+// checkSynced returns ErrLocallyModified if the disk at path does not match its vnode.
+func (vt *VTree) checkSynced(path string) error {
+	vt.RLock()
+	defer vt.RUnlock()
+	vn, err := vt.find(path)
+	if err != nil {
+		return err
+	}
+	if !vn.isSynced() {
+		return ErrLocallyModified
+	}
+	return nil
+}
+
+// isSynced returns true if the disk at the vnode path holds the vnode and
+// its links only, ignored paths aside. Missing paths are synced.
+func (vn *VNode) isSynced() bool {
+	p := vn.AbsPath()
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	code, target, err := vn.settings.classify(p, fi)
+	if err != nil || code != vn.Type {
+		return false
+	}
+	switch code {
+	case LinkCode:
+		return target == vn.Target
+	case FileCode:
+		source := db.NewSource(p)
+		return source != nil && vn.Source != nil && vn.IsSourceSame(source)
+	}
+
+	files, err := ioutil.ReadDir(p)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		abspath := filepath.Join(p, f.Name())
+		code, _, err := vn.settings.classify(abspath, f)
+		if err != nil || vn.settings.ignored(abspath, code == DirCode) {
+			continue
+		}
+		n, err := vn.FindChild(vn.GenChildID(filepath.Join(vn.Path, f.Name())))
+		if err != nil || !n.isSynced() {
+			return false
+		}
+	}
+	return true
+}
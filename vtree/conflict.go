@@ -0,0 +1,219 @@
+package vtree
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/utils"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// KeepBothPolicy keeps the version of the winning device at the path and
+	// writes the other version to a conflict copy next to it.
+	KeepBothPolicy = "keep_both"
+	// NewestPolicy keeps the version with the latest modification time.
+	NewestPolicy = "newest"
+	// LocalPolicy keeps the local version.
+	LocalPolicy = "local"
+
+	// KeptLocal represents a conflict resolved by keeping the local version.
+	KeptLocal = "local"
+	// KeptRemote represents a conflict resolved by writing the remote version.
+	KeptRemote = "remote"
+	// KeptBoth represents a conflict resolved by writing a conflict copy.
+	KeptBoth = "both"
+
+	// CONFLICTPREFIX is the db key prefix of the resolved conflicts.
+	CONFLICTPREFIX = "CONFLICT_"
+
+	// conflictDateFormat is the date format of the conflict copy names.
+	conflictDateFormat = "2006-01-02"
+)
+
+// Conflict represents a path changed locally and by a peer since the last
+// sync with the peer, and its resolution.
+type Conflict struct {
+	// Path is the conflicting path relative to the root.
+	Path string `json:"path"`
+
+	// Copy is the path relative to the root the version of the losing device
+	// is written to when both versions are kept.
+	Copy string `json:"copy,omitempty"`
+
+	// Device is the name of the peer device.
+	Device string `json:"device"`
+
+	Policy    string `json:"policy"`
+	Kept      string `json:"kept"`
+	Timestamp int64  `json:"timestamp"`
+
+	// Changes holds the changes to apply to resolve the conflict.
+	Changes []Change `json:"-"`
+}
+
+// Resolve returns the resolution of the conflicts found by Merge following
+// the policy, an unknown policy keeps both versions. A modification always
+// wins over a removal. When both versions are kept the remote version is
+// written to the path if remoteWins and the local version to the conflict
+// copy, both devices must agree on the winner to converge. The conflict
+// copies are named after the losing device, the remote device or the vtree
+// owner, and the date, the conflicts already resolved by a copy are skipped.
+func (vt *VTree) Resolve(conflicts []Change, policy, device string, remoteWins bool, now time.Time) []Conflict {
+	if policy != LocalPolicy && policy != NewestPolicy {
+		policy = KeepBothPolicy
+	}
+	resolved := []Conflict{}
+	for _, c := range conflicts {
+		conflict := Conflict{
+			Path:      c.Path,
+			Device:    device,
+			Policy:    policy,
+			Kept:      KeptLocal,
+			Timestamp: now.UnixNano(),
+		}
+		switch {
+		case c.To == nil:
+			// Removed remotely, the local version is kept.
+		case c.From == nil:
+			// Removed locally, the remote version is written back.
+			conflict.Kept = KeptRemote
+			conflict.Changes = []Change{{State: State{Path: c.Path, Op: AddedOp}, To: c.To}}
+		case policy == LocalPolicy:
+			// The local version is kept.
+		case policy == NewestPolicy:
+			if modTime(c.To) > modTime(c.From) {
+				conflict.Kept = KeptRemote
+				conflict.Changes = replace(c.From, c.To)
+			}
+		case remoteWins:
+			n, ok := vt.conflictCopy(c.From, vt.Owner(), now)
+			if !ok {
+				continue
+			}
+			conflict.Kept = KeptBoth
+			conflict.Copy = n.GetPath()
+			// The local version is copied before being replaced.
+			conflict.Changes = append([]Change{{State: State{Path: n.GetPath(), Op: AddedOp}, To: n}}, replace(c.From, c.To)...)
+		default:
+			n, ok := vt.conflictCopy(c.To, device, now)
+			if !ok {
+				continue
+			}
+			conflict.Kept = KeptBoth
+			conflict.Copy = n.GetPath()
+			conflict.Changes = []Change{{State: State{Path: n.GetPath(), Op: AddedOp}, To: n}}
+		}
+		resolved = append(resolved, conflict)
+	}
+	return resolved
+}
+
+// replace returns the changes replacing the local node by the remote node.
+func replace(local, remote *pb.FSNode) []Change {
+	if local.GetType() != remote.GetType() {
+		return []Change{
+			{State: State{Path: local.GetPath(), Op: RemovedOp}, From: local},
+			{State: State{Path: remote.GetPath(), Op: AddedOp}, To: remote},
+		}
+	}
+	return []Change{{State: State{Path: remote.GetPath(), Op: ModifiedOp}, From: local, To: remote}}
+}
+
+// conflictCopy returns the node relocated to the first free conflict copy
+// path, false if a copy of the node already exists.
+func (vt *VTree) conflictCopy(n *pb.FSNode, device string, now time.Time) (*pb.FSNode, bool) {
+	vt.RLock()
+	defer vt.RUnlock()
+	vt.refresh()
+	for i := 1; ; i++ {
+		p := ConflictPath(n.GetPath(), n.GetType() == pb.FSNode_DIR, device, now, i)
+		vn, err := vt.Head.FindChildAt(p)
+		if err != nil && !utils.PathExists(filepath.Join(vt.RootPath(), p)) {
+			return relocateProto(n, p), true
+		}
+		if err == nil && vn.Type == typeCode(n.GetType()) && vn.hash == n.GetMerkleHash() {
+			return nil, false
+		}
+	}
+}
+
+// ConflictPath returns the path of the nth conflict copy of the path:
+// "name (conflict from <device> <date>).ext", the extension of the dirs
+// and dot files is kept in the name. The path separators and ".." are
+// stripped from the device name so the copy stays next to the path.
+func ConflictPath(path string, isDir bool, device string, date time.Time, n int) string {
+	device = strings.NewReplacer("/", "", "\\", "", "..", "").Replace(device)
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	if isDir || ext == name {
+		ext = ""
+	}
+	suffix := fmt.Sprintf("conflict from %s %s", device, date.Format(conflictDateFormat))
+	if n > 1 {
+		suffix = fmt.Sprintf("%s %d", suffix, n)
+	}
+	name = fmt.Sprintf("%s (%s)%s", strings.TrimSuffix(name, ext), suffix, ext)
+	return filepath.Join(filepath.Dir(path), name)
+}
+
+// relocateProto returns a copy of the protobuf node and its links moved to path.
+func relocateProto(n *pb.FSNode, path string) *pb.FSNode {
+	c := truncateProto(n, 0)
+	c.Path = path
+	for _, link := range n.GetLinks() {
+		c.Links = append(c.Links, relocateProto(link, filepath.Join(path, filepath.Base(link.GetPath()))))
+	}
+	return c
+}
+
+// modTime returns the latest modification time of the node and its links.
+func modTime(n *pb.FSNode) int64 {
+	t := n.GetModTime()
+	for _, link := range n.GetLinks() {
+		if lt := modTime(link); lt > t {
+			t = lt
+		}
+	}
+	return t
+}
+
+// typeCode returns the vnode type of the protobuf node type.
+func typeCode(t pb.FSNode_Type) int {
+	switch t {
+	case pb.FSNode_DIR:
+		return DirCode
+	case pb.FSNode_LINK:
+		return LinkCode
+	}
+	return FileCode
+}
+
+// SaveConflict saves the resolved conflict so it can be listed.
+func SaveConflict(c Conflict) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return db.Put(utils.ToByte(fmt.Sprintf("%s%020d_%s", CONFLICTPREFIX, c.Timestamp, c.Path)), data)
+}
+
+// Conflicts returns the saved conflicts from the oldest to the latest.
+func Conflicts() ([]Conflict, error) {
+	conflicts := []Conflict{}
+	iter := db.Db.NewIterator(util.BytesPrefix(utils.ToByte(CONFLICTPREFIX)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		c := Conflict{}
+		if err := json.Unmarshal(iter.Value(), &c); err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, iter.Error()
+}
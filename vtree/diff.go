@@ -0,0 +1,137 @@
+package vtree
+
+import (
+	"sort"
+
+	"github.com/orbit-drive/orbit-drive/pb"
+)
+
+// Change represents a difference between two trees.
+type Change struct {
+	State
+
+	// From holds the node before the change, nil when added.
+	From *pb.FSNode
+
+	// To holds the node after the change, nil when removed.
+	To *pb.FSNode
+}
+
+// Diff returns the changes turning the vtree into the given tree,
+// usually received from a peer.
+func (vt *VTree) Diff(to *pb.FSTree) []Change {
+	return Diff(vt.ToProto(), to)
+}
+
+// Diff walks the from and to trees and returns the changes turning the
+// from tree into the to tree, sorted by path. Subtrees with the same merkle
+// hash are skipped. An added or removed dir is a single change, an added
+// vnode with the same merkle hash as a removed vnode is reported as moved.
+// Mod time only changes are not reported since the merkle hash excludes
+// the mod time. Trees hashed by another merkle scheme are hashed again.
+func Diff(from, to *pb.FSTree) []Change {
+	d := &differ{}
+	d.diff(rehash(from).GetHead(), rehash(to).GetHead())
+	return d.result()
+}
+
+// differ collects the changes found while walking two trees.
+type differ struct {
+	changes []Change
+	added   []*pb.FSNode
+	removed []*pb.FSNode
+}
+
+// result returns the changes found sorted by path, the added nodes matching
+// a removed node are reported as moved.
+func (d *differ) result() []Change {
+	changes := d.changes
+	matched := make(map[*pb.FSNode]bool)
+	for _, n := range d.added {
+		if match := matchProto(d.removed, matched, n); match != nil {
+			matched[match] = true
+			changes = append(changes, Change{
+				State: State{Path: n.GetPath(), Op: MovedOp, OldPath: match.GetPath()},
+				From:  match,
+				To:    n,
+			})
+			continue
+		}
+		changes = append(changes, Change{State: State{Path: n.GetPath(), Op: AddedOp}, To: n})
+	}
+	for _, n := range d.removed {
+		if !matched[n] {
+			changes = append(changes, Change{State: State{Path: n.GetPath(), Op: RemovedOp}, From: n})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// diff compares the from and to nodes found at the same path.
+func (d *differ) diff(from, to *pb.FSNode) {
+	if from.GetType() != to.GetType() {
+		d.removed = append(d.removed, from)
+		d.added = append(d.added, to)
+		return
+	}
+	if from.GetMerkleHash() == to.GetMerkleHash() {
+		return
+	}
+
+	switch to.GetType() {
+	case pb.FSNode_DIR:
+		fromLinks := make(map[string]*pb.FSNode)
+		for _, link := range from.GetLinks() {
+			fromLinks[link.GetPath()] = link
+		}
+		for _, link := range to.GetLinks() {
+			prev, ok := fromLinks[link.GetPath()]
+			if !ok {
+				d.added = append(d.added, link)
+				continue
+			}
+			delete(fromLinks, link.GetPath())
+			d.diff(prev, link)
+		}
+		for _, link := range from.GetLinks() {
+			if _, ok := fromLinks[link.GetPath()]; ok {
+				d.removed = append(d.removed, link)
+			}
+		}
+	case pb.FSNode_FILE:
+		var op opCode = ModifiedOp
+		if from.GetChecksum() == to.GetChecksum() && from.GetSize() == to.GetSize() {
+			op = MetadataOp
+		}
+		d.changes = append(d.changes, Change{State: State{Path: to.GetPath(), Op: op}, From: from, To: to})
+	default:
+		d.changes = append(d.changes, Change{State: State{Path: to.GetPath(), Op: ModifiedOp}, From: from, To: to})
+	}
+}
+
+// matchProto returns the first node not yet matched with the same type
+// and merkle hash as the given node.
+func matchProto(nodes []*pb.FSNode, matched map[*pb.FSNode]bool, n *pb.FSNode) *pb.FSNode {
+	if n.GetMerkleHash() == "" {
+		return nil
+	}
+	for _, node := range nodes {
+		if !matched[node] && node.GetType() == n.GetType() && node.GetMerkleHash() == n.GetMerkleHash() {
+			return node
+		}
+	}
+	return nil
+}
+
+// rehash returns the tree with its merkle hashes computed by the current
+// merkle scheme.
+func rehash(fst *pb.FSTree) *pb.FSTree {
+	if fst.GetMerkleAlgo() == MERKLEALGO {
+		return fst
+	}
+	return NewVTreeFromProto(fst, nil).ToProto()
+}
@@ -0,0 +1,189 @@
+package vtree
+
+import (
+	"sort"
+	"time"
+
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/pb"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetentionPolicy represents the rules selecting the snapshots to keep,
+// a zero value disables the rule. The latest snapshot is always kept.
+type RetentionPolicy struct {
+	// KeepLast is the amount of latest snapshots to keep.
+	KeepLast int
+
+	// KeepDaily is the duration to keep the last snapshot of each day.
+	KeepDaily time.Duration
+
+	// KeepDeleted is the duration to keep the last snapshot holding
+	// a file missing from the current vtree.
+	KeepDeleted time.Duration
+}
+
+// GCReport represents the result of a garbage collection.
+type GCReport struct {
+	// Pruned holds the merkle hashes of the deleted snapshots.
+	Pruned []string
+
+	// Unpinned holds the ipfs hashes of the unpinned contents.
+	Unpinned []string
+
+	// Bytes is the total size of the unpinned contents.
+	Bytes int64
+}
+
+// GC deletes the snapshots not retained by the policy and unpins the
+// contents pinned in the store or sourced in the db that are not referenced
+// by the current VTree or a retained snapshot, so the contents replaced
+// before being captured in a snapshot are unpinned too. Nothing is deleted
+// nor unpinned on a dry run.
+func (vt *VTree) GC(policy RetentionPolicy, now time.Time, dryRun bool) (*GCReport, error) {
+	// Listed before the current vtree is read so a content uploaded in
+	// between is not a candidate.
+	pins, err := vt.Store().Pins()
+	if err != nil {
+		return nil, err
+	}
+	sources, err := db.GetSources(ReservedPrefixes()...)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	current := vt.ToProto().GetHead()
+	retained := policy.retain(snapshots, current, now)
+
+	// Contents shared by deduplicated files are kept as long as
+	// a single reference remains.
+	referenced := make(map[string]bool)
+	for src := range sourceSizes(current) {
+		referenced[src] = true
+	}
+	for i, snapshot := range snapshots {
+		if !retained[i] {
+			continue
+		}
+		for src := range sourceSizes(snapshot.GetTree().GetHead()) {
+			referenced[src] = true
+		}
+	}
+
+	// The candidates map the contents to their size, -1 if unknown.
+	candidates := make(map[string]int64)
+	for _, src := range pins {
+		candidates[src] = -1
+	}
+	for _, source := range sources {
+		if source.Src != "" {
+			candidates[source.Src] = source.Size
+		}
+	}
+	report := &GCReport{}
+	for i, snapshot := range snapshots {
+		if retained[i] {
+			continue
+		}
+		report.Pruned = append(report.Pruned, snapshot.GetMerkleHash())
+		for src, size := range sourceSizes(snapshot.GetTree().GetHead()) {
+			candidates[src] = size
+		}
+		if !dryRun {
+			if err := db.Db.Delete(snapshotKey(snapshot.GetTimestamp()), nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for src, size := range candidates {
+		if referenced[src] {
+			continue
+		}
+		if size < 0 {
+			size, _ = vt.Store().Stat(src)
+		}
+		report.Unpinned = append(report.Unpinned, src)
+		report.Bytes += size
+	}
+	sort.Strings(report.Unpinned)
+
+	if dryRun {
+		return report, nil
+	}
+	for _, src := range report.Unpinned {
+		if err := vt.Store().Unpin(src); err != nil {
+			log.WithField("src", src).Warn(err)
+		}
+	}
+	return report, nil
+}
+
+// retain returns the indexes of the snapshots kept by the policy.
+func (p RetentionPolicy) retain(snapshots []*pb.Snapshot, current *pb.FSNode, now time.Time) map[int]bool {
+	retained := make(map[int]bool)
+	if len(snapshots) == 0 {
+		return retained
+	}
+	retained[len(snapshots)-1] = true
+
+	for i := len(snapshots) - p.KeepLast; i < len(snapshots); i++ {
+		if i >= 0 {
+			retained[i] = true
+		}
+	}
+
+	if p.KeepDaily > 0 {
+		days := make(map[string]bool)
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			timestamp := time.Unix(0, snapshots[i].GetTimestamp())
+			day := timestamp.Format("2006-01-02")
+			if now.Sub(timestamp) > p.KeepDaily || days[day] {
+				continue
+			}
+			days[day] = true
+			retained[i] = true
+		}
+	}
+
+	if p.KeepDeleted > 0 {
+		currentNodes := flattenProto(current)
+		deleted := make(map[string]bool)
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			timestamp := time.Unix(0, snapshots[i].GetTimestamp())
+			if now.Sub(timestamp) > p.KeepDeleted {
+				break
+			}
+			for path, n := range flattenProto(snapshots[i].GetTree().GetHead()) {
+				if n.GetType() == pb.FSNode_DIR || deleted[path] {
+					continue
+				}
+				if _, ok := currentNodes[path]; !ok {
+					deleted[path] = true
+					retained[i] = true
+				}
+			}
+		}
+	}
+	return retained
+}
+
+// sourceSizes maps the ipfs hash of all the files under n to their size.
+func sourceSizes(n *pb.FSNode) map[string]int64 {
+	sizes := make(map[string]int64)
+	if n.GetType() != pb.FSNode_DIR {
+		if n.GetSource() != "" {
+			sizes[n.GetSource()] = n.GetSize()
+		}
+		return sizes
+	}
+	for _, link := range n.GetLinks() {
+		for src, size := range sourceSizes(link) {
+			sizes[src] = size
+		}
+	}
+	return sizes
+}
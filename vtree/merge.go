@@ -0,0 +1,134 @@
+package vtree
+
+import (
+	"sort"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/utils"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// SYNCBASEPREFIX is the db key prefix of the peer trees saved after each sync.
+	SYNCBASEPREFIX = "SYNCBASE_"
+)
+
+// Merge compares the local and remote trees with the base tree, the remote
+// tree saved after the last sync, and returns the remote changes to apply to
+// the local tree and the conflicts: the paths changed differently on both
+// sides. The local changes are kept, a nil base is an empty tree so nothing
+// is removed on the first sync. The changes are sorted by path, the removed
+// paths first.
+func Merge(base, local, remote *pb.FSTree) (changes, conflicts []Change) {
+	m := &merger{}
+	m.merge(rehash(base).GetHead(), rehash(local).GetHead(), rehash(remote).GetHead())
+
+	changes = m.result()
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Op == RemovedOp && changes[j].Op != RemovedOp
+	})
+	return changes, m.conflicts
+}
+
+// merger collects the remote changes found while walking three trees.
+type merger struct {
+	differ
+	conflicts []Change
+}
+
+// merge compares the base, local and remote nodes found at the same path,
+// the nodes are nil when missing from their tree.
+func (m *merger) merge(base, local, remote *pb.FSNode) {
+	switch {
+	case sameProto(base, remote), sameProto(local, remote):
+		// Unchanged remotely or already applied.
+	case sameProto(base, local):
+		m.apply(local, remote)
+	case local != nil && remote != nil && local.GetType() == pb.FSNode_DIR && remote.GetType() == pb.FSNode_DIR:
+		baseLinks := linksByPath(base)
+		localLinks := linksByPath(local)
+		for _, link := range remote.GetLinks() {
+			m.merge(baseLinks[link.GetPath()], localLinks[link.GetPath()], link)
+			delete(localLinks, link.GetPath())
+		}
+		// The paths missing remotely are removed if unchanged locally.
+		for _, link := range local.GetLinks() {
+			if _, ok := localLinks[link.GetPath()]; ok {
+				m.merge(baseLinks[link.GetPath()], link, nil)
+			}
+		}
+	default:
+		m.conflicts = append(m.conflicts, conflict(local, remote))
+	}
+}
+
+// apply records the changes turning the local node into the remote node.
+func (m *merger) apply(local, remote *pb.FSNode) {
+	switch {
+	case local == nil:
+		m.added = append(m.added, remote)
+	case remote == nil:
+		m.removed = append(m.removed, local)
+	default:
+		m.diff(local, remote)
+	}
+}
+
+// conflict returns the change of the remote node conflicting with the local node.
+func conflict(local, remote *pb.FSNode) Change {
+	var op opCode = ModifiedOp
+	switch {
+	case local == nil:
+		op = AddedOp
+	case remote == nil:
+		return Change{State: State{Path: local.GetPath(), Op: RemovedOp}, From: local}
+	}
+	return Change{State: State{Path: remote.GetPath(), Op: op}, From: local, To: remote}
+}
+
+// sameProto returns true if both nodes are missing or have the same type and merkle hash.
+func sameProto(a, b *pb.FSNode) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.GetType() == b.GetType() && a.GetMerkleHash() == b.GetMerkleHash()
+}
+
+// linksByPath maps the links of the node by path.
+func linksByPath(n *pb.FSNode) map[string]*pb.FSNode {
+	links := make(map[string]*pb.FSNode)
+	for _, link := range n.GetLinks() {
+		links[link.GetPath()] = link
+	}
+	return links
+}
+
+// LoadBase returns the tree of the peer saved after the last sync with it,
+// nil if never synced.
+func LoadBase(peerID string) (*pb.FSTree, error) {
+	data, err := db.Get(baseKey(peerID))
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fst := &pb.FSTree{}
+	return fst, proto.Unmarshal(data, fst)
+}
+
+// SaveBase saves the tree of the peer once synced, it is the base of the
+// next merge with the peer.
+func SaveBase(peerID string, fst *pb.FSTree) error {
+	data, err := proto.Marshal(fst)
+	if err != nil {
+		return err
+	}
+	return db.Put(baseKey(peerID), data)
+}
+
+func baseKey(peerID string) []byte {
+	return utils.ToByte(SYNCBASEPREFIX + peerID)
+}
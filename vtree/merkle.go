@@ -0,0 +1,210 @@
+package vtree
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/utils"
+)
+
+const (
+	// MERKLEALGO identifies the merkle hashing scheme, saved alongside the
+	// merkle hashes so hashes computed by different schemes are not compared.
+	MERKLEALGO = "orbit-merkle-sha256-v1"
+)
+
+// merkleEntry represents a dir child as hashed in the dir merkle hash.
+type merkleEntry struct {
+	name string
+	hash string
+}
+
+// MerkleHash returns the merkle hash of the vnode. The hash covers the
+// type, the metadata and the content of the vnode but not its name, so a
+// moved vnode keeps its hash. A file hashes its permission bits and content
+// checksum, a link its target and a dir the name and merkle hash of each
+// child sorted by name. The mod time is left out so the same tree hashes
+// the same on every device. An empty dir has an empty hash.
+func (vn *VNode) MerkleHash() string {
+	vn.refresh()
+	return vn.hash
+}
+
+// markDirty marks the vnode and its ancestors as modified, stops at the
+// first dirty ancestor since all its own ancestors are dirty already.
+func (vn *VNode) markDirty() {
+	for n := vn; n != nil && !n.dirty; n = n.parent {
+		n.dirty = true
+	}
+}
+
+// refresh computes again the merkle hash and protobuf of the vnode and
+// its dirty children, the clean children reuse their cached values.
+func (vn *VNode) refresh() {
+	if !vn.dirty {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, vnode := range vn.Links {
+		if !vnode.dirty {
+			continue
+		}
+		wg.Add(1)
+		go func(vnode *VNode) {
+			vnode.refresh()
+			wg.Done()
+		}(vnode)
+	}
+	wg.Wait()
+
+	vn.hash = vn.merkleHash()
+	vn.proto = vn.newProto()
+	vn.dirty = false
+}
+
+// merkleHash hashes the vnode from the cached hashes of its children.
+func (vn *VNode) merkleHash() string {
+	switch {
+	case vn.IsLink():
+		return hashEntry(LinkCode, vn.Target)
+	case !vn.IsDir():
+		s := vn.Source
+		if s == nil {
+			return hashEntry(FileCode)
+		}
+		return hashEntry(FileCode, fmt.Sprintf("%o", s.Mode), s.Checksum)
+	case len(vn.Links) == 0:
+		return ""
+	}
+
+	entries := make([]merkleEntry, len(vn.Links))
+	for i, vnode := range vn.Links {
+		entries[i] = merkleEntry{name: vnode.GetName(), hash: vnode.hash}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	fields := make([]string, 0, 2*len(entries))
+	for _, e := range entries {
+		fields = append(fields, e.name, e.hash)
+	}
+	return hashEntry(DirCode, fields...)
+}
+
+// newProto parse the vnode to protobuf from the cached protobuf of its
+// children. A new protobuf is returned so the protobuf cached before are
+// left untouched.
+func (vn *VNode) newProto() *pb.FSNode {
+	pbNode := &pb.FSNode{
+		ID:         vn.ID,
+		Path:       vn.Path,
+		Type:       pb.FSNode_FILE,
+		Links:      make([]*pb.FSNode, len(vn.Links)),
+		MerkleHash: vn.hash,
+	}
+
+	if vn.IsDir() {
+		pbNode.Type = pb.FSNode_DIR
+	} else if vn.IsLink() {
+		pbNode.Type = pb.FSNode_LINK
+		pbNode.Target = vn.Target
+	} else if vn.Source != nil {
+		pbNode.Source = vn.Source.Src
+		pbNode.Size = vn.Source.Size
+		pbNode.Checksum = vn.Source.Checksum
+		pbNode.Mode = vn.Source.Mode
+		pbNode.ModTime = vn.Source.ModTime
+		pbNode.Executable = vn.Source.IsExecutable()
+	}
+
+	for i, vnode := range vn.Links {
+		pbNode.Links[i] = vnode.proto
+	}
+	return pbNode
+}
+
+// hashEntry hashes the algorithm id, the vnode type and the fields
+// separated by NUL bytes, which can not appear in file names.
+func hashEntry(code int, fields ...string) string {
+	return utils.HashStrToHex(fmt.Sprintf("%s\x00%d\x00%s", MERKLEALGO, code, strings.Join(fields, "\x00")))
+}
+
+// Subtree returns the protobuf of the vnode at the path relative to the
+// root with depth levels of links, all the links for a depth of 0.
+func (vt *VTree) Subtree(path string, depth int) (*pb.FSNode, error) {
+	vt.RLock()
+	defer vt.RUnlock()
+	vn, err := vt.Head.FindChildAt(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	return vt.subtree(vn, depth), nil
+}
+
+// SubtreeByID returns the protobuf of the vnode with the given id with
+// depth levels of links, all the links for a depth of 0. The whole vtree
+// is searched, Subtree should be preferred when the path is known.
+func (vt *VTree) SubtreeByID(id []byte, depth int) (*pb.FSNode, error) {
+	vt.RLock()
+	defer vt.RUnlock()
+	vn := vt.Head.findByID(id)
+	if vn == nil {
+		return nil, ErrVNodeNotFound
+	}
+	return vt.subtree(vn, depth), nil
+}
+
+// subtree returns the cached protobuf of the vnode cut to depth levels of
+// links, the read lock must be held.
+func (vt *VTree) subtree(vn *VNode, depth int) *pb.FSNode {
+	vt.refresh()
+	if depth <= 0 {
+		return vn.proto
+	}
+	return truncateProto(vn.proto, depth)
+}
+
+// findByID returns the vnode with the given id under the vnode, nil if none.
+func (vn *VNode) findByID(id []byte) *VNode {
+	if bytes.Equal(vn.ID, id) {
+		return vn
+	}
+	for _, vnode := range vn.Links {
+		if n := vnode.findByID(id); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// truncateProto returns a copy of the protobuf node keeping depth levels of
+// links, the cached protobuf are left untouched.
+func truncateProto(n *pb.FSNode, depth int) *pb.FSNode {
+	c := &pb.FSNode{
+		ID:         n.GetID(),
+		Path:       n.GetPath(),
+		Type:       n.GetType(),
+		Source:     n.GetSource(),
+		Size:       n.GetSize(),
+		Checksum:   n.GetChecksum(),
+		MerkleHash: n.GetMerkleHash(),
+		Mode:       n.GetMode(),
+		ModTime:    n.GetModTime(),
+		Executable: n.GetExecutable(),
+		Target:     n.GetTarget(),
+	}
+	if depth == 0 {
+		return c
+	}
+	c.Links = make([]*pb.FSNode, len(n.GetLinks()))
+	for i, link := range n.GetLinks() {
+		c.Links[i] = truncateProto(link, depth-1)
+	}
+	return c
+}
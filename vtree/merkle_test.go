@@ -0,0 +1,129 @@
+package vtree
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/ipfs"
+)
+
+// genVTree generates in memory a vtree of dirs nested depth times, each
+// dir holding fanout dirs and files files. Returns the vtree and its
+// deepest file.
+func genVTree(depth, fanout, files int) (*VTree, *VNode) {
+	vt := NewVTree("/orbit-drive-bench", ipfs.NewMemStore())
+	var deepest *VNode
+	var gen func(dir *VNode, depth int)
+	gen = func(dir *VNode, depth int) {
+		for i := 0; i < files; i++ {
+			p := filepath.Join(dir.Path, fmt.Sprintf("file%d", i))
+			n := &VNode{ID: dir.GenChildID(p), Path: p, Type: FileCode, Links: []*VNode{}, dirty: true}
+			n.Source = &db.Source{Size: int64(i), Checksum: fmt.Sprintf("%032x", i), Mode: 0644}
+			dir.LinkChild(n)
+			deepest = n
+		}
+		if depth == 0 {
+			return
+		}
+		for i := 0; i < fanout; i++ {
+			p := filepath.Join(dir.Path, fmt.Sprintf("dir%d", i))
+			n := &VNode{ID: dir.GenChildID(p), Path: p, Type: DirCode, Links: []*VNode{}, dirty: true}
+			dir.LinkChild(n)
+			gen(n, depth-1)
+		}
+	}
+	gen(vt.Head, depth)
+	return vt, deepest
+}
+
+// markAllDirty drops the cached merkle hash and protobuf of the vnode
+// and all its children.
+func markAllDirty(vn *VNode) {
+	vn.dirty = true
+	for _, vnode := range vn.Links {
+		markAllDirty(vnode)
+	}
+}
+
+// editSource replaces the source of the file vnode by a source with
+// new content.
+func editSource(vn *VNode, i int) {
+	vn.SetSource(&db.Source{Size: int64(i), Checksum: fmt.Sprintf("%032x", i), Mode: 0644})
+}
+
+func TestMerkleCache(t *testing.T) {
+	vt, file := genVTree(3, 3, 3)
+	hash, pbTree := vt.MerkleHash(), vt.ToProto()
+
+	editSource(file, 1000)
+	edited := vt.MerkleHash()
+	if edited == hash {
+		t.Error("Expected file edit to change the root hash")
+	}
+	if pbTree.GetHead().GetMerkleHash() != hash {
+		t.Error("Expected protobuf returned before the edit to be left untouched")
+	}
+
+	cached := vt.ToProto()
+	markAllDirty(vt.Head)
+	if vt.MerkleHash() != edited {
+		t.Errorf("Expected cached root hash %s to equal full hash %s", edited, vt.MerkleHash())
+	}
+	if !proto.Equal(cached, vt.ToProto()) {
+		t.Error("Expected cached protobuf to equal full protobuf")
+	}
+
+	if _, err := vt.Head.UnlinkChild("dir0"); err != nil {
+		t.Fatal(err)
+	}
+	removed := vt.MerkleHash()
+	markAllDirty(vt.Head)
+	if removed == edited || vt.MerkleHash() != removed {
+		t.Errorf("Expected unlink to change the cached root hash, got: %s", removed)
+	}
+}
+
+// benchSizes holds the depth, fanout and files per dir of the benchmark
+// trees, from ~1k to ~500k files.
+var benchSizes = [][3]int{{3, 4, 12}, {4, 6, 30}, {5, 8, 15}}
+
+func benchVTree(b *testing.B, full bool, fn func(vt *VTree)) {
+	for _, size := range benchSizes {
+		vt, file := genVTree(size[0], size[1], size[2])
+		fn(vt)
+		b.Run(fmt.Sprintf("depth=%d/fanout=%d/files=%d", size[0], size[1], size[2]), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				editSource(file, i)
+				if full {
+					markAllDirty(vt.Head)
+				}
+				fn(vt)
+			}
+		})
+	}
+}
+
+// BenchmarkMerkleHashFull hashes the whole tree after each edit, as
+// before the merkle hashes were cached.
+func BenchmarkMerkleHashFull(b *testing.B) {
+	benchVTree(b, true, func(vt *VTree) { vt.MerkleHash() })
+}
+
+// BenchmarkMerkleHash hashes only the edited file ancestors.
+func BenchmarkMerkleHash(b *testing.B) {
+	benchVTree(b, false, func(vt *VTree) { vt.MerkleHash() })
+}
+
+// BenchmarkToProtoFull parse the whole tree after each edit, as before
+// the protobuf were cached.
+func BenchmarkToProtoFull(b *testing.B) {
+	benchVTree(b, true, func(vt *VTree) { vt.ToProto() })
+}
+
+// BenchmarkToProto parse only the edited file ancestors.
+func BenchmarkToProto(b *testing.B) {
+	benchVTree(b, false, func(vt *VTree) { vt.ToProto() })
+}
@@ -0,0 +1,144 @@
+package vtree
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/utils"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// MigratePaths converts the saved vtree and snapshots holding absolute
+// paths to root-relative paths and ids, the sources saved in the db are
+// re-keyed to the new ids. Trees already migrated are left untouched.
+func MigratePaths() error {
+	b := new(leveldb.Batch)
+
+	data, err := db.Get(utils.ToByte(ROOTKEY))
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	if err == nil {
+		fst := &pb.FSTree{}
+		if err := proto.Unmarshal(data, fst); err != nil {
+			return err
+		}
+		if isAbsTree(fst) {
+			for oldID, newID := range migrateTree(fst) {
+				source, err := db.Get(utils.ToByte(oldID))
+				if err != nil {
+					continue
+				}
+				b.Delete(utils.ToByte(oldID))
+				b.Put(newID, source)
+			}
+			if err := putProto(b, utils.ToByte(ROOTKEY), fst); err != nil {
+				return err
+			}
+		}
+	}
+
+	iter := db.Db.NewIterator(util.BytesPrefix(utils.ToByte(SNAPSHOTPREFIX)), nil)
+	for iter.Next() {
+		snapshot := &pb.Snapshot{}
+		if err := proto.Unmarshal(iter.Value(), snapshot); err != nil {
+			iter.Release()
+			return err
+		}
+		if !isAbsTree(snapshot.GetTree()) {
+			continue
+		}
+		migrateTree(snapshot.GetTree())
+		if err := putProto(b, append([]byte{}, iter.Key()...), snapshot); err != nil {
+			iter.Release()
+			return err
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return db.Db.Write(b, nil)
+}
+
+// MigrateSources re-keys the sources saved before the ids were generated
+// from root-relative paths, when no vtree is saved yet. The old id of each
+// file under root is chained from ROOTKEY over the absolute paths.
+func MigrateSources(root string) error {
+	_, err := db.Get(utils.ToByte(ROOTKEY))
+	if err != leveldb.ErrNotFound {
+		return err
+	}
+	b := new(leveldb.Batch)
+	id := utils.ToByte(ROOTKEY)
+	if err := migrateSources(b, filepath.Clean(root), ".", id, id); err != nil {
+		return err
+	}
+	return db.Db.Write(b, nil)
+}
+
+func migrateSources(b *leveldb.Batch, dir, rel string, oldID, newID []byte) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		abspath := filepath.Join(dir, f.Name())
+		path := filepath.Join(rel, f.Name())
+		oldChildID, newChildID := genChildID(oldID, abspath), genChildID(newID, path)
+		if f.IsDir() {
+			// Unreadable dirs are left to the build.
+			migrateSources(b, abspath, path, oldChildID, newChildID)
+			continue
+		}
+		source, err := db.Get(oldChildID)
+		if err != nil {
+			continue
+		}
+		b.Delete(oldChildID)
+		b.Put(newChildID, source)
+	}
+	return nil
+}
+
+// isAbsTree returns true if the tree was saved with absolute paths.
+func isAbsTree(fst *pb.FSTree) bool {
+	return fst.GetRoot() == "" && filepath.IsAbs(fst.GetHead().GetPath())
+}
+
+// migrateTree converts the tree paths to root-relative paths and
+// regenerates the ids, returns the new ids mapped by old id.
+func migrateTree(fst *pb.FSTree) map[string][]byte {
+	ids := make(map[string][]byte)
+	root := fst.GetHead().GetPath()
+	fst.Root = root
+	fst.Head.Path = "."
+	migrateNode(fst.GetHead(), root, ids)
+	return ids
+}
+
+func migrateNode(n *pb.FSNode, root string, ids map[string][]byte) {
+	for _, link := range n.GetLinks() {
+		rel, err := filepath.Rel(root, link.GetPath())
+		if err != nil {
+			rel = filepath.Base(link.GetPath())
+		}
+		newID := genChildID(n.GetID(), rel)
+		ids[utils.ToStr(link.GetID())] = newID
+		link.ID, link.Path = newID, rel
+		migrateNode(link, root, ids)
+	}
+}
+
+func putProto(b *leveldb.Batch, k []byte, m proto.Message) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	b.Put(k, data)
+	return nil
+}
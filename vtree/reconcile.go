@@ -0,0 +1,285 @@
+package vtree
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/ipfs"
+	log "github.com/sirupsen/logrus"
+)
+
+// changes holds the differences found between the vtree and the disk.
+type changes struct {
+	// added holds the new vnodes not linked to the vtree yet, their sources
+	// are not uploaded.
+	added []*VNode
+
+	// modified holds the paths of the updated link vnodes.
+	modified []string
+
+	// uploads holds the file vnodes and their new sources waiting for the
+	// upload of their content.
+	uploads []pendingSource
+
+	// metadata holds the paths of the file vnodes with only their metadata updated.
+	metadata []string
+
+	// removed holds the vnodes unlinked from the vtree.
+	removed []*VNode
+}
+
+// pendingSource represents the new source of a file vnode, set once its
+// content is uploaded.
+type pendingSource struct {
+	vn      *VNode
+	abspath string
+	source  *db.Source
+}
+
+// Reconcile updates the VTree to match the current content of the disk
+// and returns the state changes found. Vnodes matching the content of a
+// removed vnode are reported as moved and reuse the uploaded sources. The
+// files are uploaded without the lock held, the new vnodes and sources are
+// linked afterwards and the files failing to upload are left to the next
+// change or start.
+func (vt *VTree) Reconcile() ([]State, error) {
+	vt.Lock()
+	c := &changes{}
+	err := vt.Head.reconcile(c)
+	if err == nil {
+		// Deleted before the new sources are saved, a path changing type keeps its id.
+		err = db.BatchDelete(c.removedIDs())
+	}
+	store := vt.Head.store
+	vt.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Index the removed sources by checksum to reuse uploaded sources.
+	srcs := make(map[string]string)
+	for _, n := range c.removed {
+		for _, source := range n.AllSources() {
+			if source.Src != "" {
+				srcs[source.Checksum] = source.Src
+			}
+		}
+	}
+	for _, n := range c.added {
+		n.walkFiles(func(f *VNode) {
+			if f.Source == nil {
+				return
+			}
+			src, ok := srcs[f.Source.Checksum]
+			if !ok {
+				src, ok = upload(store, f.AbsPath())
+			}
+			if ok {
+				f.Source.SetSrc(src)
+				f.markDirty()
+			}
+		})
+	}
+	for _, m := range c.uploads {
+		if src, ok := upload(store, m.abspath); ok {
+			m.source.SetSrc(src)
+		}
+	}
+
+	vt.Lock()
+	defer vt.Unlock()
+	states := []State{}
+	for _, p := range c.modified {
+		states = append(states, State{Path: p, Op: ModifiedOp})
+	}
+	for _, m := range c.uploads {
+		state, err := vt.setSource(m)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			states = append(states, *state)
+		}
+	}
+	for _, p := range c.metadata {
+		states = append(states, State{Path: p, Op: MetadataOp})
+	}
+
+	moved := make(map[*VNode]bool)
+	for _, n := range c.added {
+		if n.Source != nil && !n.Source.IsNew() {
+			// Added again on the next change or start.
+			continue
+		}
+		if _, _, err := vt.add(n); err != nil {
+			log.WithField("path", n.Path).Warn(err)
+			continue
+		}
+		if err := n.AllSources().Save(); err != nil {
+			return nil, err
+		}
+
+		if match := findMatch(c.removed, moved, n); match != nil {
+			moved[match] = true
+			states = append(states, State{Path: n.Path, Op: MovedOp, OldPath: match.Path})
+			continue
+		}
+		states = append(states, State{Path: n.Path, Op: AddedOp})
+	}
+
+	for _, n := range c.removed {
+		if !moved[n] {
+			states = append(states, State{Path: n.Path, Op: RemovedOp})
+		}
+	}
+	return states, nil
+}
+
+// removedIDs returns the ids of the removed vnodes and their links.
+func (c *changes) removedIDs() [][]byte {
+	ids := [][]byte{}
+	for _, n := range c.removed {
+		ids = append(ids, n.AllIDs()...)
+	}
+	return ids
+}
+
+// upload returns the cid of the file content, false if the upload failed.
+func upload(store ipfs.ContentStore, p string) (string, bool) {
+	src, err := ipfs.UploadFile(store, p)
+	if err != nil {
+		log.WithField("path", p).Warn(err)
+		return "", false
+	}
+	return src, true
+}
+
+// setSource sets the uploaded source of the file vnode if it is still
+// linked at the same path, returns the state of the change if any.
+func (vt *VTree) setSource(m pendingSource) (*State, error) {
+	vn, err := vt.Head.FindChildAt(m.vn.Path)
+	if err != nil || vn != m.vn || vn.AbsPath() != m.abspath || !m.source.IsNew() {
+		return nil, nil
+	}
+	state := &State{Path: vn.Path, Op: ModifiedOp}
+	if vn.IsSourceSame(m.source) {
+		state.Op = MetadataOp
+		if vn.Source.IsSameMeta(m.source) {
+			// Uploaded again after a failed upload.
+			state = nil
+		}
+	}
+	vn.SetSource(m.source)
+	return state, vn.Source.Save(vn.ID)
+}
+
+// findMatch returns the first vnode not yet matched with the same type
+// and merkle hash as the given vnode.
+func findMatch(vnodes []*VNode, matched map[*VNode]bool, vn *VNode) *VNode {
+	h := vn.MerkleHash()
+	if h == "" {
+		return nil
+	}
+	for _, n := range vnodes {
+		if !matched[n] && n.Type == vn.Type && n.MerkleHash() == h {
+			return n
+		}
+	}
+	return nil
+}
+
+// reconcile read the vnode dir and compares it with its links: metadata
+// changes are updated, modified files and new paths are collected to be
+// uploaded and missing paths are unlinked. All the differences are
+// collected in c.
+func (vn *VNode) reconcile(c *changes) error {
+	files, err := ioutil.ReadDir(vn.AbsPath())
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range files {
+		abspath := filepath.Join(vn.AbsPath(), f.Name())
+		code, target, err := vn.settings.classify(abspath, f)
+		if err != nil || vn.settings.ignored(abspath, code == DirCode) {
+			continue
+		}
+		path := filepath.Join(vn.Path, f.Name())
+		seen[path] = true
+
+		n, err := vn.FindChild(vn.GenChildID(path))
+		if err == nil && n.Type != code {
+			// Path type changed, replace the vnode.
+			vn.UnlinkChild(path)
+			c.removed = append(c.removed, n)
+			err = ErrVNodeNotFound
+		}
+		if err != nil {
+			nn := vn.newChild(path)
+			switch code {
+			case LinkCode:
+				nn.Type = LinkCode
+				nn.Target = target
+			case DirCode:
+				nn.SetAsDir()
+				nn.PopulateNodes(db.Sources{}, false)
+			default:
+				nn.Source = db.NewSource(abspath)
+			}
+			c.added = append(c.added, nn)
+			continue
+		}
+
+		switch n.Type {
+		case DirCode:
+			if err := n.reconcile(c); err != nil {
+				return err
+			}
+		case LinkCode:
+			if err := n.UpdateTarget(); err == nil {
+				c.modified = append(c.modified, path)
+			}
+		default:
+			source := db.NewSource(abspath)
+			if source == nil {
+				continue
+			}
+			if !n.IsSourceSame(source) || !n.Source.IsNew() {
+				c.uploads = append(c.uploads, pendingSource{vn: n, abspath: abspath, source: source})
+				continue
+			}
+			if n.Source.IsSameMeta(source) {
+				continue
+			}
+			// Metadata only changes keep the uploaded content.
+			source.SetSrc(n.Source.GetSrc())
+			n.SetSource(source)
+			if err := n.Source.Save(n.ID); err != nil {
+				return err
+			}
+			c.metadata = append(c.metadata, path)
+		}
+	}
+
+	for _, n := range append([]*VNode{}, vn.Links...) {
+		if seen[n.Path] {
+			continue
+		}
+		vn.UnlinkChild(n.Path)
+		c.removed = append(c.removed, n)
+	}
+	return nil
+}
+
+// walkFiles calls fn for the vnode and all its children of type file.
+func (vn *VNode) walkFiles(fn func(*VNode)) {
+	if !vn.IsDir() {
+		fn(vn)
+		return
+	}
+	for _, vnode := range vn.Links {
+		vnode.walkFiles(fn)
+	}
+}
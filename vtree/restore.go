@@ -0,0 +1,222 @@
+package vtree
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/ipfs"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/utils"
+)
+
+var (
+	// ErrSourceNotUploaded is returned when restoring a file with no ipfs hash.
+	ErrSourceNotUploaded = errors.New("vtree: source not uploaded")
+
+	// ErrSizeMismatch is returned when the restored content size differs from the source.
+	ErrSizeMismatch = errors.New("vtree: restored size does not match source")
+
+	// ErrChecksumMismatch is returned when the restored content checksum differs from the source.
+	ErrChecksumMismatch = errors.New("vtree: restored checksum does not match source")
+
+	// ErrLocallyModified is returned when restoring over a file modified since it was synced.
+	ErrLocallyModified = errors.New("vtree: file locally modified")
+)
+
+const (
+	// RestoredStatus represents a file written to disk.
+	RestoredStatus = "restored"
+	// UpToDateStatus represents a file already matching its source on disk.
+	UpToDateStatus = "up to date"
+	// DryRunStatus represents a file that would be written to disk.
+	DryRunStatus = "would restore"
+	// FailedStatus represents a file that could not be restored.
+	FailedStatus = "failed"
+)
+
+// RestoreResult represents the outcome of restoring a single file.
+type RestoreResult struct {
+	// Path is the absolute path the file is restored to.
+	Path string
+
+	// Src is the ipfs hash of the restored content.
+	Src string
+
+	Status string
+	Err    error
+}
+
+// Restorer restores vnodes to disk and collects the result of each file.
+type Restorer struct {
+	// DryRun only reports the files that would be restored.
+	DryRun bool
+
+	// Force overwrites the files modified since they were synced.
+	Force bool
+
+	// Current is the vtree of the synced root, a file existing on disk is
+	// locally modified if it differs from its source in the current vtree.
+	Current *VTree
+
+	// Results holds the result of each restored file.
+	Results []RestoreResult
+}
+
+// RestoreProto downloads the content of a protobuf node and all its links
+// from the content store and writes it at the dst path.
+func RestoreProto(store ipfs.ContentStore, n *pb.FSNode, dst string) error {
+	return NewVNodeFromProto(n, store).Restore(dst)
+}
+
+// Restore downloads the content of the vnode and all its links from the
+// content store and writes it at the dst path, dirs are recreated.
+// Returns the first error encountered.
+func (vn *VNode) Restore(dst string) error {
+	r := &Restorer{Force: true}
+	r.Restore(vn, dst)
+	return r.Err()
+}
+
+// Restore writes the vnode and all its links at the dst path and records
+// the result of each file.
+func (r *Restorer) Restore(vn *VNode, dst string) {
+	if vn.IsDir() {
+		for _, vnode := range vn.Links {
+			r.Restore(vnode, filepath.Join(dst, vnode.GetName()))
+		}
+		if !r.DryRun && vn.LinksCount() == 0 {
+			os.MkdirAll(dst, os.ModePerm)
+		}
+		return
+	}
+	if vn.IsLink() {
+		r.restoreLink(vn, dst)
+		return
+	}
+
+	result := RestoreResult{Path: dst, Status: FailedStatus}
+	if vn.Source != nil {
+		result.Src = vn.Source.GetSrc()
+	}
+	switch err := r.check(vn, dst); err {
+	case nil:
+		result.Status = DryRunStatus
+		if !r.DryRun {
+			if result.Err = restoreFile(vn.store, vn.Source, dst); result.Err == nil {
+				result.Status = RestoredStatus
+			}
+		}
+	case ErrIsUpToDate:
+		result.Status = UpToDateStatus
+		if !r.DryRun {
+			result.Err = applyMeta(vn.Source, dst)
+		}
+	default:
+		result.Err = err
+	}
+	r.Results = append(r.Results, result)
+}
+
+// Err returns the first error of the results.
+func (r *Restorer) Err() error {
+	for _, result := range r.Results {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+	return nil
+}
+
+// check validates the vnode can be restored at dst, returns ErrIsUpToDate if
+// dst already matches the vnode source.
+func (r *Restorer) check(vn *VNode, dst string) error {
+	if vn.Source == nil || vn.Source.GetSrc() == "" {
+		return ErrSourceNotUploaded
+	}
+	if !utils.PathExists(dst) {
+		return nil
+	}
+	local := db.NewSource(dst)
+	if local == nil {
+		return ErrLocallyModified
+	}
+	if local.IsSame(vn.Source) {
+		return ErrIsUpToDate
+	}
+	if r.Force {
+		return nil
+	}
+	if r.Current != nil {
+		if synced, err := r.Current.Find(dst); err == nil && synced.Source != nil && synced.IsSourceSame(local) {
+			return nil
+		}
+	}
+	return ErrLocallyModified
+}
+
+// restoreFile streams the source content to a temp file next to dst,
+// verifies its size and checksum and renames it to dst.
+func restoreFile(store ipfs.ContentStore, s *db.Source, dst string) error {
+	if s == nil || s.GetSrc() == "" {
+		return ErrSourceNotUploaded
+	}
+	r, err := store.Get(s.GetSrc())
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	// Hidden temp file so it is ignored by the watcher.
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".orbit-restore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if size != s.Size {
+		return ErrSizeMismatch
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != s.Checksum {
+		return ErrChecksumMismatch
+	}
+	if err := applyMeta(s, tmp.Name()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// applyMeta sets the mode and modification time of the source to the
+// file at p, sources saved without them default to 0644 and now.
+func applyMeta(s *db.Source, p string) error {
+	mode := os.FileMode(s.Mode).Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.Chmod(p, mode); err != nil {
+		return err
+	}
+	if s.ModTime == 0 {
+		return nil
+	}
+	modTime := time.Unix(0, s.ModTime)
+	return os.Chtimes(p, modTime, modTime)
+}
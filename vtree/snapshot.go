@@ -0,0 +1,180 @@
+package vtree
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/ipfs"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/utils"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// SNAPSHOTPREFIX is the db key prefix of the saved snapshots.
+	SNAPSHOTPREFIX = "SNAPSHOT_"
+)
+
+var (
+	// ErrSnapshotNotFound is returned when no snapshot matches a merkle hash.
+	ErrSnapshotNotFound = errors.New("vtree: snapshot not found")
+)
+
+// FileVersion represents a distinct version of a file across the snapshots.
+type FileVersion struct {
+	// Timestamp is the time of the first snapshot holding the version.
+	Timestamp time.Time
+
+	// MerkleHash is the merkle root hash of the first snapshot holding the version.
+	MerkleHash string
+
+	Src      string
+	Size     int64
+	Checksum string
+}
+
+// SaveSnapshot saves the current VTree as a snapshot if its merkle hash
+// differs from the latest snapshot, returns the latest snapshot.
+func (vt *VTree) SaveSnapshot() (*pb.Snapshot, error) {
+	latest, err := LatestSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	// The hash and the tree are read under the same lock so they match.
+	vt.RLock()
+	tree := vt.toProto()
+	vt.RUnlock()
+	hash := tree.GetHead().GetMerkleHash()
+	if latest != nil && latest.GetMerkleAlgo() == MERKLEALGO && latest.GetMerkleHash() == hash {
+		return latest, nil
+	}
+
+	snapshot := &pb.Snapshot{
+		Timestamp:  time.Now().UnixNano(),
+		MerkleHash: hash,
+		MerkleAlgo: MERKLEALGO,
+		Tree:       tree,
+	}
+	data, err := proto.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, db.Put(snapshotKey(snapshot.GetTimestamp()), data)
+}
+
+// NewVTreeFromSnapshot initialize a VTree from a snapshot tree.
+func NewVTreeFromSnapshot(snapshot *pb.Snapshot, store ipfs.ContentStore) *VTree {
+	return NewVTreeFromProto(snapshot.GetTree(), store)
+}
+
+// Snapshots returns all the saved snapshots from the oldest to the latest.
+func Snapshots() ([]*pb.Snapshot, error) {
+	snapshots := []*pb.Snapshot{}
+	iter := db.Db.NewIterator(util.BytesPrefix(utils.ToByte(SNAPSHOTPREFIX)), nil)
+	for iter.Next() {
+		snapshot := &pb.Snapshot{}
+		if err := proto.Unmarshal(iter.Value(), snapshot); err != nil {
+			iter.Release()
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	iter.Release()
+	return snapshots, iter.Error()
+}
+
+// LatestSnapshot returns the latest saved snapshot, nil if none was saved.
+func LatestSnapshot() (*pb.Snapshot, error) {
+	iter := db.Db.NewIterator(util.BytesPrefix(utils.ToByte(SNAPSHOTPREFIX)), nil)
+	defer iter.Release()
+	if !iter.Last() {
+		return nil, iter.Error()
+	}
+	snapshot := &pb.Snapshot{}
+	return snapshot, proto.Unmarshal(iter.Value(), snapshot)
+}
+
+// FindSnapshot returns the latest snapshot with the given merkle hash.
+func FindSnapshot(hash string) (*pb.Snapshot, error) {
+	snapshots, err := Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].GetMerkleHash() == hash {
+			return snapshots[i], nil
+		}
+	}
+	return nil, ErrSnapshotNotFound
+}
+
+// FileVersions returns the distinct versions of the file at the path
+// relative to the root across all the snapshots, from the oldest to the latest.
+func FileVersions(path string) ([]FileVersion, error) {
+	snapshots, err := Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []FileVersion{}
+	for _, snapshot := range snapshots {
+		n := findProto(snapshot.GetTree().GetHead(), path)
+		if n == nil || n.GetType() == pb.FSNode_DIR {
+			continue
+		}
+		if len(versions) > 0 && versions[len(versions)-1].Checksum == n.GetChecksum() {
+			continue
+		}
+		versions = append(versions, FileVersion{
+			Timestamp:  time.Unix(0, snapshot.GetTimestamp()),
+			MerkleHash: snapshot.GetMerkleHash(),
+			Src:        n.GetSource(),
+			Size:       n.GetSize(),
+			Checksum:   n.GetChecksum(),
+		})
+	}
+	return versions, nil
+}
+
+// DiffSnapshots returns the states changing the from snapshot into the to snapshot.
+func DiffSnapshots(from, to *pb.Snapshot) []State {
+	states := []State{}
+	for _, change := range Diff(from.GetTree(), to.GetTree()) {
+		states = append(states, change.State)
+	}
+	return states
+}
+
+// findProto look for the protobuf node at path under the node n.
+func findProto(n *pb.FSNode, path string) *pb.FSNode {
+	if n.GetPath() == path {
+		return n
+	}
+	for _, link := range n.GetLinks() {
+		if link.GetPath() == path || strings.HasPrefix(path, link.GetPath()+string(filepath.Separator)) {
+			return findProto(link, path)
+		}
+	}
+	return nil
+}
+
+// flattenProto maps all the protobuf nodes under n by path, n excluded.
+func flattenProto(n *pb.FSNode) map[string]*pb.FSNode {
+	nodes := make(map[string]*pb.FSNode)
+	for _, link := range n.GetLinks() {
+		nodes[link.GetPath()] = link
+		for p, child := range flattenProto(link) {
+			nodes[p] = child
+		}
+	}
+	return nodes
+}
+
+func snapshotKey(timestamp int64) []byte {
+	return utils.ToByte(fmt.Sprintf("%s%020d", SNAPSHOTPREFIX, timestamp))
+}
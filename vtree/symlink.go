@@ -0,0 +1,182 @@
+package vtree
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/orbit-drive/orbit-drive/ignore"
+	"github.com/orbit-drive/orbit-drive/utils"
+)
+
+const (
+	// PreserveSymlinks stores the symbolic links as links to their target.
+	PreserveSymlinks = "preserve"
+
+	// FollowSymlinks stores the content of the links targeting a path in
+	// the root, other links and links creating a cycle are preserved.
+	FollowSymlinks = "follow"
+
+	// SkipSymlinks ignores the symbolic links.
+	SkipSymlinks = "skip"
+)
+
+// settings holds the settings shared by all the vnodes of a vtree.
+type settings struct {
+	// root is the absolute path of the vtree.
+	root string
+
+	// matcher holds the ignore patterns.
+	matcher *ignore.Matcher
+
+	// symlinks is the symlink policy. (Default: PreserveSymlinks)
+	symlinks string
+}
+
+func newSettings(root string) *settings {
+	return &settings{
+		root:     root,
+		matcher:  ignore.NewMatcher(root, nil),
+		symlinks: PreserveSymlinks,
+	}
+}
+
+// ignored returns true if the path matches the ignore patterns.
+func (s *settings) ignored(p string, isDir bool) bool {
+	return s != nil && s.matcher.Ignored(p, isDir)
+}
+
+// classify returns the vnode type of the path given its lstat info and
+// the target of a link following the symlink policy. Returns ErrIgnored
+// for the links skipped.
+func (s *settings) classify(p string, fi os.FileInfo) (int, string, error) {
+	if fi.Mode()&os.ModeSymlink == 0 {
+		if fi.IsDir() {
+			return DirCode, "", nil
+		}
+		return FileCode, "", nil
+	}
+	target, err := os.Readlink(p)
+	if err != nil {
+		return FileCode, "", err
+	}
+	switch s.symlinks {
+	case SkipSymlinks:
+		return FileCode, "", ErrIgnored
+	case FollowSymlinks:
+		if real, ok := s.followable(p); ok {
+			if isDir, _ := utils.IsDir(real); isDir {
+				return DirCode, "", nil
+			}
+			return FileCode, "", nil
+		}
+	}
+	return LinkCode, target, nil
+}
+
+// followable returns the real path of the link at p if it resolves in the
+// root without creating a cycle: the target is not a parent of the link.
+func (s *settings) followable(p string) (string, bool) {
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", false
+	}
+	root, err := filepath.EvalSymlinks(s.root)
+	if err != nil || !isWithin(real, root) {
+		return "", false
+	}
+	// Parents are resolved too, a cycle can span several links.
+	for dir := filepath.Dir(p); isWithin(dir, s.root); dir = filepath.Dir(dir) {
+		parent, err := filepath.EvalSymlinks(dir)
+		if err != nil || isWithin(parent, real) {
+			return "", false
+		}
+		if dir == s.root {
+			break
+		}
+	}
+	return real, true
+}
+
+// isWithin returns true if p is dir or under dir.
+func isWithin(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+string(filepath.Separator))
+}
+
+// IsLink returns true if the vnode is of type linkcode.
+func (vn *VNode) IsLink() bool {
+	return vn.Type == LinkCode
+}
+
+// NewLinkVNode initialize and returns a new link VNode under current vnode
+// given its path relative to the root.
+func (vn *VNode) NewLinkVNode(path, target string) *VNode {
+	n := &VNode{
+		ID:       vn.GenChildID(path),
+		Path:     path,
+		Type:     LinkCode,
+		Links:    []*VNode{},
+		Target:   target,
+		store:    vn.store,
+		settings: vn.settings,
+		dirty:    true,
+	}
+	vn.LinkChild(n)
+	return n
+}
+
+// UpdateTarget reads the target of the link vnode, returns ErrIsUpToDate
+// if it did not change.
+func (vn *VNode) UpdateTarget() error {
+	target, err := os.Readlink(vn.AbsPath())
+	if err != nil {
+		return err
+	}
+	if target == vn.Target {
+		return ErrIsUpToDate
+	}
+	vn.Target = target
+	vn.markDirty()
+	return nil
+}
+
+// restoreLink creates the link vnode at dst and records the result.
+func (r *Restorer) restoreLink(vn *VNode, dst string) {
+	result := RestoreResult{Path: dst, Status: FailedStatus}
+	defer func() {
+		r.Results = append(r.Results, result)
+	}()
+
+	if _, err := os.Lstat(dst); err == nil {
+		target, _ := os.Readlink(dst)
+		if target == vn.Target {
+			result.Status = UpToDateStatus
+			return
+		}
+		if !r.Force && !r.isSyncedLink(dst, target) {
+			result.Err = ErrLocallyModified
+			return
+		}
+	}
+	if r.DryRun {
+		result.Status = DryRunStatus
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		result.Err = err
+		return
+	}
+	os.Remove(dst)
+	if result.Err = os.Symlink(vn.Target, dst); result.Err == nil {
+		result.Status = RestoredStatus
+	}
+}
+
+// isSyncedLink returns true if the current vtree holds the link at p with the given target.
+func (r *Restorer) isSyncedLink(p, target string) bool {
+	if r.Current == nil || target == "" {
+		return false
+	}
+	synced, err := r.Current.Find(p)
+	return err == nil && synced.IsLink() && synced.Target == target
+}
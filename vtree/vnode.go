@@ -20,6 +20,8 @@ const (
 	FileCode = iota
 	// DirCode represents a dir
 	DirCode = iota
+	// LinkCode represents a symbolic link
+	LinkCode = iota
 )
 
 var (
@@ -31,15 +33,18 @@ var (
 
 	// ErrIsUpToDate is returned when saving/updating a update to vnode.
 	ErrIsUpToDate = errors.New("vnode already up to date")
+
+	// ErrIgnored is returned when uploading a file matching an ignore pattern.
+	ErrIgnored = errors.New("vnode path is ignored")
 )
 
 // VNode represents a file structure where each node can be (i) a dir (ii) a file.
 // If is a file, Source links to the ipfs hash saved on the network.
 type VNode struct {
-	// Id is generated from the absolute path and refers to the key used to save to leveldb.
+	// Id is generated from the parent id and the path, refers to the key used to save to leveldb.
 	ID []byte `json:"_id"`
 
-	// Path holds the absolute path in the os file system <- Need to compress to relative path.
+	// Path holds the path relative to the vtree root, the root path is ".".
 	Path string `json:"path"`
 
 	// Type represents if the vnode is a file or dir.
@@ -50,6 +55,26 @@ type VNode struct {
 
 	// Source refers to the ipfs hash generated by the network.error
 	Source *db.Source `json:"source"`
+
+	// Target holds the target of a symbolic link.
+	Target string `json:"target"`
+
+	// store is the content store the file sources are uploaded to.
+	store ipfs.ContentStore
+
+	// settings holds the settings shared by the whole vtree.
+	settings *settings
+
+	// parent is the dir vnode linking the vnode, nil for the head.
+	parent *VNode
+
+	// dirty is true when the cached merkle hash and protobuf are outdated,
+	// a dirty vnode has all its ancestors dirty.
+	dirty bool
+
+	// hash and proto cache the merkle hash and protobuf of the vnode.
+	hash  string
+	proto *pb.FSNode
 }
 
 // GetID parse the vtree id to string and returns.
@@ -57,11 +82,19 @@ func (vn *VNode) GetID() string {
 	return utils.ToStr(vn.ID)
 }
 
-// GetPath returns the absolute vnode path.
+// GetPath returns the vnode path relative to the root.
 func (vn *VNode) GetPath() string {
 	return vn.Path
 }
 
+// AbsPath returns the vnode path in the os file system.
+func (vn *VNode) AbsPath() string {
+	if vn.settings == nil {
+		return vn.Path
+	}
+	return filepath.Join(vn.settings.root, vn.Path)
+}
+
 // GetName returns the actual dir/file name.
 func (vn *VNode) GetName() string {
 	return utils.ExtractFileName(vn.GetPath())
@@ -75,16 +108,19 @@ func (vn *VNode) LinksCount() int {
 // SetAsDir sets the vnode type to a dir.
 func (vn *VNode) SetAsDir() {
 	vn.Type = DirCode
+	vn.markDirty()
 }
 
 // SetAsFile sets the vnode type to a file.
 func (vn *VNode) SetAsFile() {
 	vn.Type = FileCode
+	vn.markDirty()
 }
 
 // SetSource sets the vnode source to the provided source.
 func (vn *VNode) SetSource(s *db.Source) {
 	vn.Source = s
+	vn.markDirty()
 }
 
 // IsNew returns true if the vnode source has not been uploaded.
@@ -106,62 +142,125 @@ func (vn *VNode) IsSourceSame(source *db.Source) bool {
 // SaveSource upload a file path to the ipfs network and
 // save the return hash as the source of the vnode.
 func (vn *VNode) SaveSource() error {
+	if vn.settings.ignored(vn.AbsPath(), false) {
+		return ErrIgnored
+	}
 	// If ipfs hash empty, then upload to ipfs network.
 	if !vn.IsNew() {
-		s, err := ipfs.UploadFile(vn.Path)
+		s, err := ipfs.UploadFile(vn.store, vn.AbsPath())
 		if err != nil {
 			return err
 		}
 		vn.Source.SetSrc(s)
+		vn.markDirty()
 		return vn.Source.Save(vn.ID)
 	}
 	return ErrIsUpToDate
 }
 
 // UpdateSource validates and updates source if given source file differ from current source.
+// Metadata only changes keep the uploaded content.
 func (vn *VNode) UpdateSource(source *db.Source) error {
 	if vn.IsSourceSame(source) {
-		return ErrIsUpToDate
+		if vn.Source.IsSameMeta(source) {
+			return ErrIsUpToDate
+		}
+		source.SetSrc(vn.Source.GetSrc())
+		vn.SetSource(source)
+		return vn.Source.Save(vn.ID)
 	}
 	vn.SetSource(source)
 	return vn.SaveSource()
 }
 
-// GenChildID returns a hash from the current vnode id and the given path.
+// GenChildID returns a hash from the current vnode id and the given relative path.
 func (vn *VNode) GenChildID(p string) []byte {
-	i := append(vn.ID, p...)
+	return genChildID(vn.ID, p)
+}
+
+func genChildID(parentID []byte, p string) []byte {
+	i := make([]byte, 0, len(parentID)+len(p))
+	i = append(append(i, parentID...), p...)
 	return utils.HashBytes(i)
 }
 
-// NewVNode initialize and returns a new VNode under current vnode.
+// NewVNode initialize and returns a new VNode under current vnode
+// given its path relative to the root.
 func (vn *VNode) NewVNode(path string) *VNode {
-	i := append(vn.ID, path...)
-	n := &VNode{
-		ID:     utils.HashStr(utils.ToStr(i)),
-		Path:   path,
-		Links:  []*VNode{},
-		Source: db.NewSource(path),
-	}
-	vn.Links = append(vn.Links, n)
+	n := vn.newChild(path)
+	n.Source = db.NewSource(n.AbsPath())
+	vn.LinkChild(n)
 	return n
 }
 
+// newChild initialize and returns a new VNode given its path relative to
+// the root, with no source and not linked to the current vnode.
+func (vn *VNode) newChild(path string) *VNode {
+	return &VNode{
+		ID:       vn.GenChildID(path),
+		Path:     path,
+		Links:    []*VNode{},
+		store:    vn.store,
+		settings: vn.settings,
+		dirty:    true,
+	}
+}
+
+// NewVNodeFromProto parse a protobuf to a vnode and its links
+// uploading to the given content store.
+func NewVNodeFromProto(n *pb.FSNode, store ipfs.ContentStore) *VNode {
+	vn := &VNode{
+		ID:    n.GetID(),
+		Path:  n.GetPath(),
+		Type:  FileCode,
+		Links: []*VNode{},
+		store: store,
+		dirty: true,
+	}
+	switch n.GetType() {
+	case pb.FSNode_DIR:
+		vn.SetAsDir()
+		for _, link := range n.GetLinks() {
+			vn.LinkChild(NewVNodeFromProto(link, store))
+		}
+		return vn
+	case pb.FSNode_LINK:
+		vn.Type = LinkCode
+		vn.Target = n.GetTarget()
+		return vn
+	}
+	vn.SetSource(&db.Source{
+		Src:      n.GetSource(),
+		Size:     n.GetSize(),
+		Checksum: n.GetChecksum(),
+		Mode:     n.GetMode(),
+		ModTime:  n.GetModTime(),
+	})
+	return vn
+}
+
 // PopulateNodes read a path and populate the its links given
 // the path is a directory else creates a file node.RemoveFromWatchList
 func (vn *VNode) PopulateNodes(s db.Sources, upload bool) error {
-	files, err := ioutil.ReadDir(vn.Path)
+	files, err := ioutil.ReadDir(vn.AbsPath())
 	if err != nil {
 		return err
 	}
 
 	var wg sync.WaitGroup
 	for _, f := range files {
-		abspath := filepath.Join(vn.Path, f.Name())
-		if utils.IsHidden(abspath) {
+		abspath := filepath.Join(vn.AbsPath(), f.Name())
+		code, target, err := vn.settings.classify(abspath, f)
+		if err != nil || vn.settings.ignored(abspath, code == DirCode) {
+			continue
+		}
+		path := filepath.Join(vn.Path, f.Name())
+		if code == LinkCode {
+			vn.NewLinkVNode(path, target)
 			continue
 		}
-		nn := vn.NewVNode(abspath)
-		if f.IsDir() {
+		nn := vn.NewVNode(path)
+		if code == DirCode {
 			nn.SetAsDir()
 			nn.PopulateNodes(s, upload)
 			continue
@@ -169,7 +268,13 @@ func (vn *VNode) PopulateNodes(s db.Sources, upload bool) error {
 
 		source := s.ExtractSource(nn.GetID())
 		if nn.Source.IsSame(source) {
-			nn.SetSource(source)
+			nn.Source.SetSrc(source.GetSrc())
+			if !nn.Source.IsSameMeta(source) {
+				nn.Source.Save(nn.ID)
+			}
+			continue
+		}
+		if !upload {
 			continue
 		}
 		wg.Add(1)
@@ -183,13 +288,13 @@ func (vn *VNode) PopulateNodes(s db.Sources, upload bool) error {
 	return nil
 }
 
-// FindChildAt perform a full traversal to look a vnode from a given path.
+// FindChildAt perform a full traversal to look a vnode from a given path relative to the root.
 func (vn *VNode) FindChildAt(path string) (*VNode, error) {
 	rel, err := filepath.Rel(vn.Path, path)
 	if err != nil || rel == "." {
 		return vn, err
 	}
-	steps := strings.Split(rel, "/")
+	steps := strings.Split(rel, string(filepath.Separator))
 	return vn.traverse(steps)
 }
 
@@ -207,9 +312,23 @@ func (vn *VNode) FindChild(i []byte) (*VNode, error) {
 	return vn, ErrVNodeNotFound
 }
 
-// UnlinkChild traverse a VTree and remove the VNode at the given path.
-func (vn *VNode) UnlinkChild(path string) error {
-	return nil
+// UnlinkChild look for the child at the given path from its Links (1 level),
+// removes it and returns the unlinked vnode.
+func (vn *VNode) UnlinkChild(path string) (*VNode, error) {
+	if !vn.IsDir() {
+		return vn, ErrNotADir
+	}
+
+	i := vn.GenChildID(path)
+	for idx, n := range vn.Links {
+		if bytes.Equal(n.ID, i) {
+			vn.Links = append(vn.Links[:idx], vn.Links[idx+1:]...)
+			n.parent = nil
+			vn.markDirty()
+			return n, nil
+		}
+	}
+	return vn, ErrVNodeNotFound
 }
 
 // traverse traverse a VNode 1 level at a time down the tree.
@@ -231,47 +350,78 @@ func (vn *VNode) traverse(steps []string) (*VNode, error) {
 	return vn, nil
 }
 
-// ToProto parse a vtree to protobuf.
+// ToProto parse a vtree to protobuf. The protobuf is cached until the
+// vnode is modified and must not be modified by the caller.
 func (vn *VNode) ToProto() *pb.FSNode {
-	pbNode := &pb.FSNode{
-		ID:    vn.ID,
-		Path:  vn.Path,
-		Links: []*pb.FSNode{},
-	}
+	vn.refresh()
+	return vn.proto
+}
 
+// AllDirPaths traverse the vnode links and returns a slice of all the child
+// dir paths in the os file system.
+func (vn *VNode) AllDirPaths() []string {
 	if !vn.IsDir() {
-		pbNode.Source = vn.Source.Src
+		return []string{}
+	}
+	dirPaths := []string{vn.AbsPath()}
+	for _, vnode := range vn.Links {
+		dirPaths = append(dirPaths, vnode.AllDirPaths()...)
 	}
+	return dirPaths
+}
 
-	var wg sync.WaitGroup
+// setSettings sets the settings of the vnode and all its children.
+func (vn *VNode) setSettings(s *settings) {
+	vn.settings = s
 	for _, vnode := range vn.Links {
-		wg.Add(1)
-		go func(vn *VNode) {
-			pbNode.Links = append(pbNode.Links, vn.ToProto())
-			wg.Done()
-		}(vnode)
+		vnode.setSettings(s)
 	}
-	wg.Wait()
-	return pbNode
 }
 
-// AllDirPaths traverse the vnode links and returns a slice of all the child dirpath.
-func (vn *VNode) AllDirPaths() []string {
+// LinkChild adds the given vnode to its Links.
+func (vn *VNode) LinkChild(n *VNode) {
+	n.parent = vn
+	vn.Links = append(vn.Links, n)
+	vn.markDirty()
+}
+
+// Relocate sets the vnode path and regenerates the ids of the vnode
+// and all its children from the given new parent vnode.
+func (vn *VNode) Relocate(parent *VNode, path string) {
+	vn.ID = parent.GenChildID(path)
+	vn.Path = path
+	vn.markDirty()
+	for _, vnode := range vn.Links {
+		vnode.Relocate(vn, filepath.Join(path, vnode.GetName()))
+	}
+}
+
+// AllSources traverse the vnode links and returns all the file sources
+// mapped by their vnode id.
+func (vn *VNode) AllSources() db.Sources {
+	sources := make(db.Sources)
 	if !vn.IsDir() {
-		return []string{}
+		if vn.Source != nil {
+			sources[vn.GetID()] = vn.Source
+		}
+		return sources
 	}
-	var wg sync.WaitGroup
-	dirPaths := []string{vn.Path}
 	for _, vnode := range vn.Links {
-		wg.Add(1)
-		go func(vnode *VNode) {
-			paths := vnode.AllDirPaths()
-			dirPaths = append(dirPaths, paths...)
-			wg.Done()
-		}(vnode)
+		for k, source := range vnode.AllSources() {
+			sources[k] = source
+		}
 	}
-	wg.Wait()
-	return dirPaths
+	return sources
+}
+
+// AllIDs traverse the vnode links and returns a slice of all the ids
+// including the current vnode id.
+func (vn *VNode) AllIDs() [][]byte {
+	ids := [][]byte{vn.ID}
+	for _, vnode := range vn.Links {
+		ids = append(ids, vnode.AllIDs()...)
+	}
+	return ids
 }
 
 // SortLinksByID order the links of a dir by id.
@@ -284,29 +434,5 @@ func (vn *VNode) SortLinksByID() {
 		compareVal := strings.Compare(firstID, secondID)
 		return compareVal == 0 || compareVal < 0
 	})
-}
-
-// MerkleHash returns the merkle hash of the vnode.
-func (vn *VNode) MerkleHash() string {
-	if !vn.IsDir() {
-		return utils.HashStrToHex(vn.Source.Checksum)
-	}
-	if len(vn.Links) == 0 {
-		return ""
-	}
-	vn.SortLinksByID()
-
-	var hashSum string
-	var wg sync.WaitGroup
-	for _, vnode := range vn.Links {
-		wg.Add(1)
-		go func(vnode *VNode) {
-			merkleHash := vnode.MerkleHash()
-			hashSum += merkleHash
-			wg.Done()
-		}(vnode)
-	}
-	wg.Wait()
-
-	return utils.HashStrToHex(hashSum)
+	vn.markDirty()
 }
@@ -1,12 +1,18 @@
 package vtree
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/ipfs"
 	"github.com/orbit-drive/orbit-drive/pb"
 	"github.com/orbit-drive/orbit-drive/utils"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 type opCode int64
@@ -20,38 +26,126 @@ const (
 	ModifiedOp = iota
 	// RemovedOp represents the remove operation
 	RemovedOp = iota
+	// MovedOp represents the move/rename operation
+	MovedOp = iota
+	// MetadataOp represents a change of the file metadata only
+	MetadataOp = iota
+	// ConflictOp represents a path changed locally and by a peer since the last sync
+	ConflictOp = iota
 )
 
-// State represents a vtree state change.
+// String returns the operation name.
+func (op opCode) String() string {
+	switch op {
+	case AddedOp:
+		return "added"
+	case ModifiedOp:
+		return "modified"
+	case RemovedOp:
+		return "removed"
+	case MovedOp:
+		return "moved"
+	case MetadataOp:
+		return "metadata"
+	case ConflictOp:
+		return "conflict"
+	}
+	return "unknown"
+}
+
+var (
+	// ErrVTreeNotFound is returned when no vtree was saved for the root path.
+	ErrVTreeNotFound = errors.New("vtree: no saved vtree found")
+
+	// ErrNotInRoot is returned when looking for a path outside of the root.
+	ErrNotInRoot = errors.New("vtree: path is not in the root")
+)
+
+// ReservedPrefixes returns the prefixes of the db keys used by the vtree,
+// the other keys hold the file sources.
+func ReservedPrefixes() []string {
+	return []string{ROOTKEY, SNAPSHOTPREFIX, SYNCBASEPREFIX, CONFLICTPREFIX}
+}
+
+// State represents a vtree state change, paths are relative to the root.
 type State struct {
 	Path string
 	Op   opCode
+
+	// OldPath holds the previous path of a moved vnode.
+	OldPath string
 }
 
-// VTree represents the file tree structure
+// VTree represents the file tree structure. The VTree methods are safe
+// for concurrent use: the read lock is held while reading the vnodes and
+// the write lock while modifying them. The vnodes returned by Find must
+// only be modified through the VTree methods.
 type VTree struct {
-	sync.Mutex
+	sync.RWMutex
 	// Head is the root pointer to the virtual tree of the file structure being synchronized.
 	Head *VNode
 
 	// State channel
 	state chan State
+
+	// owner is the name of the device the vtree is synced from.
+	owner string
+
+	// cacheLock serializes the readers updating the cached merkle hashes
+	// and protobuf of the vnodes.
+	cacheLock sync.Mutex
 }
 
-// NewVTree initialize a new virtual tree (VTree) given an absolute path.
-func NewVTree(path string) *VTree {
+// NewVTree initialize a new virtual tree (VTree) given an absolute path
+// and the content store to upload the files to. Only the default ignore
+// patterns and the .orbitignore files apply until SetIgnorePatterns.
+func NewVTree(path string, store ipfs.ContentStore) *VTree {
 	return &VTree{
 		Head: &VNode{
-			ID:     utils.ToByte(ROOTKEY),
-			Path:   path,
-			Type:   DirCode,
-			Links:  []*VNode{},
-			Source: &db.Source{},
+			ID:       utils.ToByte(ROOTKEY),
+			Path:     ".",
+			Type:     DirCode,
+			Links:    []*VNode{},
+			Source:   &db.Source{},
+			store:    store,
+			settings: newSettings(path),
+			dirty:    true,
 		},
 		state: make(chan State),
 	}
 }
 
+// LoadVTree reads the VTree saved in the db, returns ErrVTreeNotFound
+// if none was saved for the given absolute path.
+func LoadVTree(path string, store ipfs.ContentStore) (*VTree, error) {
+	data, err := db.Get(utils.ToByte(ROOTKEY))
+	if err == leveldb.ErrNotFound {
+		return nil, ErrVTreeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fst := &pb.FSTree{}
+	if err := proto.Unmarshal(data, fst); err != nil {
+		return nil, err
+	}
+	if fst.GetRoot() != path {
+		return nil, ErrVTreeNotFound
+	}
+	return NewVTreeFromProto(fst, store), nil
+}
+
+// NewVTreeFromProto parse a protobuf to a VTree.
+func NewVTreeFromProto(fst *pb.FSTree, store ipfs.ContentStore) *VTree {
+	vt := NewVTree(fst.GetRoot(), store)
+	for _, n := range NewVNodeFromProto(fst.GetHead(), store).Links {
+		vt.Head.LinkChild(n)
+	}
+	vt.Head.setSettings(vt.Head.settings)
+	return vt
+}
+
 // StateChanges returns the state channel of the VTree.
 func (vt *VTree) StateChanges() <-chan State {
 	return vt.state
@@ -62,6 +156,78 @@ func (vt *VTree) PushToState(p string, op opCode) {
 	vt.state <- State{Path: p, Op: op}
 }
 
+// PushMoveToState generates and sends a moved State struct to the state channel.
+func (vt *VTree) PushMoveToState(oldPath, newPath string) {
+	vt.state <- State{Path: newPath, Op: MovedOp, OldPath: oldPath}
+}
+
+// PushStates sends all the given states to the state channel.
+func (vt *VTree) PushStates(states []State) {
+	for _, state := range states {
+		vt.state <- state
+	}
+}
+
+// SetIgnorePatterns sets the global ignore patterns, evaluated after the
+// default patterns and before the .orbitignore files.
+func (vt *VTree) SetIgnorePatterns(patterns []string) {
+	vt.Lock()
+	defer vt.Unlock()
+	vt.Head.settings.matcher.SetPatterns(patterns)
+}
+
+// ReloadIgnoreFile reads again the .orbitignore file of the given dir.
+func (vt *VTree) ReloadIgnoreFile(dir string) {
+	vt.Lock()
+	defer vt.Unlock()
+	vt.Head.settings.matcher.Reload(dir)
+}
+
+// SetSymlinkPolicy sets how the symbolic links are stored: PreserveSymlinks,
+// FollowSymlinks or SkipSymlinks. Applies to the vnodes added afterwards.
+func (vt *VTree) SetSymlinkPolicy(policy string) {
+	if policy == "" {
+		policy = PreserveSymlinks
+	}
+	vt.Lock()
+	defer vt.Unlock()
+	vt.Head.settings.symlinks = policy
+}
+
+// PathType returns the vnode type of the path following the symlink
+// policy, ErrIgnored is returned for the links skipped.
+func (vt *VTree) PathType(p string) (int, error) {
+	vt.RLock()
+	defer vt.RUnlock()
+	return vt.pathType(p)
+}
+
+func (vt *VTree) pathType(p string) (int, error) {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return FileCode, err
+	}
+	code, _, err := vt.Head.settings.classify(p, fi)
+	return code, err
+}
+
+// IsIgnored returns true if the path matches the ignore patterns or is a
+// skipped link. A path missing from the disk is ignored unless it is in the VTree.
+func (vt *VTree) IsIgnored(p string) bool {
+	vt.RLock()
+	defer vt.RUnlock()
+
+	code, err := vt.pathType(p)
+	if err == ErrIgnored {
+		return true
+	}
+	if err != nil {
+		_, err := vt.find(p)
+		return err != nil
+	}
+	return vt.Head.settings.ignored(p, code == DirCode)
+}
+
 // Build is a wrapper around PopulateNodes to set flag or
 // auto upload unsync files to ipfs network.
 func (vt *VTree) Build(s db.Sources) error {
@@ -71,65 +237,393 @@ func (vt *VTree) Build(s db.Sources) error {
 // PopulateNodes recursively populates the file tree structure
 // starting from the head.
 func (vt *VTree) PopulateNodes(s db.Sources, upload bool) error {
+	vt.Lock()
+	defer vt.Unlock()
 	return vt.Head.PopulateNodes(s, upload)
 }
 
+// Save parse the VTree to protobuf and write it to the db.
+func (vt *VTree) Save() error {
+	data, err := proto.Marshal(vt.ToProto())
+	if err != nil {
+		return err
+	}
+	return db.Put(utils.ToByte(ROOTKEY), data)
+}
+
 // Find recursively traverse down the tree structure from the
-// root head and returns the vnode corresponding the path.
+// root head and returns the vnode corresponding the absolute path.
 func (vt *VTree) Find(path string) (*VNode, error) {
-	return vt.Head.FindChildAt(path)
+	vt.RLock()
+	defer vt.RUnlock()
+	return vt.find(path)
 }
 
-// Add traverse VTree to locate path parent dir and add a new vnode.
-func (vt *VTree) Add(path string) error {
+func (vt *VTree) find(path string) (*VNode, error) {
+	rel, err := vt.Rel(path)
+	if err != nil {
+		return nil, err
+	}
+	return vt.Head.FindChildAt(rel)
+}
+
+// Rel returns the path relative to the root of the absolute path.
+func (vt *VTree) Rel(path string) (string, error) {
+	rel, err := filepath.Rel(vt.RootPath(), path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrNotInRoot
+	}
+	return rel, nil
+}
+
+// Add traverse VTree to locate path parent dir and add a new vnode,
+// returns the paths of all the dirs created. The files are uploaded
+// without the lock held so the readers are not blocked meanwhile.
+func (vt *VTree) Add(path string) ([]string, error) {
+	n, err := vt.newVNode(path)
+	if err != nil {
+		return nil, err
+	}
 	vt.Lock()
-	defer vt.Unlock()
+	rel, dirPaths, err := vt.add(n)
+	vt.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	vt.PushToState(rel, AddedOp)
+	return dirPaths, nil
+}
 
-	dir := filepath.Dir(path)
-	vn, err := vt.Find(dir)
+// newVNode returns the vnode of the path with the sources of its files
+// uploaded and saved, not linked to the vtree yet.
+func (vt *VTree) newVNode(path string) (*VNode, error) {
+	rel, err := vt.Rel(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	n := vn.NewVNode(path)
-	isDir, err := utils.IsDir(path)
+	vt.RLock()
+	parent, err := vt.Head.FindChildAt(filepath.Dir(rel))
 	if err != nil {
-		return err
+		vt.RUnlock()
+		return nil, err
+	}
+	n := parent.newChild(rel)
+	vt.RUnlock()
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
 	}
-	if isDir {
+	code, target, err := n.settings.classify(path, fi)
+	if err != nil {
+		return nil, err
+	}
+	switch code {
+	case LinkCode:
+		n.Type = LinkCode
+		n.Target = target
+	case DirCode:
 		n.SetAsDir()
 		// Read file content and upload
 		n.PopulateNodes(db.Sources{}, true)
-	} else {
+	default:
 		n.SetAsFile()
+		n.SetSource(db.NewSource(path))
 		n.SaveSource()
 	}
-	vt.PushToState(path, AddedOp)
-	return nil
+	return n, nil
 }
 
-// Remove -> UnlinkChild -> remove from db
-func (vt *VTree) Remove(path string) error {
-	vt.PushToState(path, RemovedOp)
-	return nil
+// add links the vnode to its parent in place of the vnode at the same path.
+func (vt *VTree) add(n *VNode) (string, []string, error) {
+	parent, err := vt.Head.FindChildAt(filepath.Dir(n.Path))
+	if err != nil {
+		return "", nil, err
+	}
+	if !parent.IsDir() {
+		return "", nil, ErrNotADir
+	}
+	if old, err := parent.UnlinkChild(n.Path); err == nil {
+		// Added meanwhile, the sources of the new vnode are saved again.
+		if err := db.BatchDelete(old.AllIDs()); err != nil {
+			return "", nil, err
+		}
+		if err := n.AllSources().Save(); err != nil {
+			return "", nil, err
+		}
+	}
+	parent.LinkChild(n)
+	return n.Path, n.AllDirPaths(), nil
+}
+
+// Update reads again the file or link at path and updates its vnode,
+// returns ErrIsUpToDate if the vnode did not change. The file content is
+// uploaded without the lock held so the readers are not blocked meanwhile.
+func (vt *VTree) Update(path string) error {
+	source, err := vt.newSource(path)
+	if err != nil {
+		return err
+	}
+	vt.Lock()
+	rel, err := vt.update(path, source)
+	vt.Unlock()
+	if err != nil && err != ErrIsUpToDate {
+		return err
+	}
+	if err == nil {
+		vt.PushToState(rel, ModifiedOp)
+	}
+	return err
+}
+
+// newSource returns the source of the file at path, its content uploaded
+// if it changed since the source of its vnode. Returns a nil source if the
+// vnode is not a file and ErrIsUpToDate if the file did not change.
+func (vt *VTree) newSource(path string) (*db.Source, error) {
+	vt.RLock()
+	vn, err := vt.find(path)
+	if err != nil || vn.Type != FileCode || vn.Source == nil {
+		vt.RUnlock()
+		return nil, err
+	}
+	current, settings, store := *vn.Source, vn.settings, vn.store
+	vt.RUnlock()
+
+	source := db.NewSource(path)
+	if source == nil {
+		return nil, ErrVNodeNotFound
+	}
+	if current.IsSame(source) && current.IsNew() {
+		if current.IsSameMeta(source) {
+			return nil, ErrIsUpToDate
+		}
+		// Metadata only changes keep the uploaded content.
+		source.SetSrc(current.GetSrc())
+		return source, nil
+	}
+	if settings.ignored(path, false) {
+		return nil, ErrIgnored
+	}
+	// The source is left unchanged if the upload fails so it is retried.
+	src, err := ipfs.UploadFile(store, path)
+	if err != nil {
+		return nil, err
+	}
+	source.SetSrc(src)
+	return source, nil
+}
+
+// update sets the source of the file at path or reads again the target of
+// the link at path.
+func (vt *VTree) update(path string, source *db.Source) (string, error) {
+	vn, err := vt.find(path)
+	if err != nil {
+		return "", err
+	}
+	if vn.IsLink() {
+		return vn.Path, vn.UpdateTarget()
+	}
+	if vn.IsDir() || source == nil {
+		return vn.Path, ErrIsUpToDate
+	}
+	vn.SetSource(source)
+	return vn.Path, vn.Source.Save(vn.ID)
+}
+
+// Remove traverse VTree to locate path parent dir, unlink the vnode and
+// deletes the sources of the vnode and all its children from the db.
+// Returns all the dir paths removed from the VTree.
+func (vt *VTree) Remove(path string) ([]string, error) {
+	vt.Lock()
+	rel, dirPaths, err := vt.remove(path)
+	vt.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	vt.PushToState(rel, RemovedOp)
+	return dirPaths, nil
+}
+
+func (vt *VTree) remove(path string) (string, []string, error) {
+	rel, err := vt.Rel(path)
+	if err != nil {
+		return "", nil, err
+	}
+	vn, err := vt.Head.FindChildAt(filepath.Dir(rel))
+	if err != nil {
+		return "", nil, err
+	}
+	n, err := vn.UnlinkChild(rel)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := db.BatchDelete(n.AllIDs()); err != nil {
+		return "", nil, err
+	}
+	return rel, n.AllDirPaths(), nil
+}
+
+// Move traverse VTree to locate the vnode at oldPath and re-link it under
+// the newPath parent dir. The ids of the moved vnode and all its children
+// are regenerated and their sources re-keyed in the db, the vtree is left
+// unchanged if the db write fails. Returns the dir paths before and after
+// the move.
+func (vt *VTree) Move(oldPath, newPath string) ([]string, []string, error) {
+	vt.Lock()
+	oldDirPaths, newDirPaths, err := vt.move(oldPath, newPath)
+	vt.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	oldRel, _ := vt.Rel(oldPath)
+	newRel, _ := vt.Rel(newPath)
+	vt.PushMoveToState(oldRel, newRel)
+	return oldDirPaths, newDirPaths, nil
+}
+
+func (vt *VTree) move(oldPath, newPath string) ([]string, []string, error) {
+	oldRel, err := vt.Rel(oldPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	newRel, err := vt.Rel(newPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	newParent, err := vt.Head.FindChildAt(filepath.Dir(newRel))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !newParent.IsDir() {
+		return nil, nil, ErrNotADir
+	}
+	oldParent, err := vt.Head.FindChildAt(filepath.Dir(oldRel))
+	if err != nil {
+		return nil, nil, err
+	}
+	vn, err := oldParent.UnlinkChild(oldRel)
+	if err != nil {
+		return nil, nil, err
+	}
+	oldDirPaths := vn.AllDirPaths()
+	oldIDs := vn.AllIDs()
+
+	// Moving over an existing path replaces the vnode.
+	var replaced *VNode
+	if n, err := newParent.UnlinkChild(newRel); err == nil {
+		replaced = n
+		oldIDs = append(oldIDs, replaced.AllIDs()...)
+	}
+
+	vn.Relocate(newParent, newRel)
+	newParent.LinkChild(vn)
+	if err := vn.AllSources().Replace(oldIDs); err != nil {
+		// The db is left untouched, the vnodes are linked back.
+		newParent.UnlinkChild(newRel)
+		if replaced != nil {
+			newParent.LinkChild(replaced)
+		}
+		vn.Relocate(oldParent, oldRel)
+		oldParent.LinkChild(vn)
+		return nil, nil, err
+	}
+	return oldDirPaths, vn.AllDirPaths(), nil
+}
+
+// IsMoved returns true if the disk at newPath holds the vnode at oldPath:
+// a dir, a link to the same target or a file with the same size and
+// checksum. Paths missing from the vtree are not compared.
+func (vt *VTree) IsMoved(oldPath, newPath string) bool {
+	vt.RLock()
+	defer vt.RUnlock()
+	vn, err := vt.find(oldPath)
+	if err != nil {
+		return true
+	}
+	fi, err := os.Lstat(newPath)
+	if err != nil {
+		return false
+	}
+	code, target, err := vn.settings.classify(newPath, fi)
+	if err != nil || code != vn.Type {
+		return false
+	}
+	switch code {
+	case LinkCode:
+		return target == vn.Target
+	case FileCode:
+		if vn.Source == nil || vn.Source.Size != fi.Size() {
+			return false
+		}
+		source := db.NewSource(newPath)
+		return source != nil && source.Checksum == vn.Source.Checksum
+	}
+	return true
 }
 
 // ToProto parse a vtree to protobuf.
 func (vt *VTree) ToProto() *pb.FSTree {
+	vt.RLock()
+	defer vt.RUnlock()
+	return vt.toProto()
+}
+
+// toProto parse the vtree to protobuf, the read lock must be held.
+func (vt *VTree) toProto() *pb.FSTree {
+	vt.refresh()
 	return &pb.FSTree{
-		Head: vt.Head.ToProto(),
+		Owner:      vt.owner,
+		Head:       vt.Head.proto,
+		Root:       vt.RootPath(),
+		MerkleAlgo: MERKLEALGO,
 	}
 }
 
+// SetOwner sets the name of the device the vtree is synced from, it names
+// the conflict copies written on the peers.
+func (vt *VTree) SetOwner(owner string) {
+	vt.Lock()
+	defer vt.Unlock()
+	vt.owner = owner
+}
+
+// Owner returns the name of the device the vtree is synced from.
+func (vt *VTree) Owner() string {
+	vt.RLock()
+	defer vt.RUnlock()
+	return vt.owner
+}
+
+// Store returns the content store the files are uploaded to.
+func (vt *VTree) Store() ipfs.ContentStore {
+	return vt.Head.store
+}
+
+// RootPath returns the absolute path of the root.
 func (vt *VTree) RootPath() string {
-	return vt.Head.GetPath()
+	return vt.Head.settings.root
 }
 
 // AllDirPaths returns all the dir path in the vtree.
 func (vt *VTree) AllDirPaths() []string {
+	vt.RLock()
+	defer vt.RUnlock()
 	return vt.Head.AllDirPaths()
 }
 
-// MerkleHash returns the merkle root hash.
+// MerkleHash returns the merkle root hash, only the vnodes modified since
+// the last call are hashed again.
 func (vt *VTree) MerkleHash() string {
-	return vt.Head.MerkleHash()
+	vt.RLock()
+	defer vt.RUnlock()
+	vt.refresh()
+	return vt.Head.hash
+}
+
+// refresh updates the cached merkle hashes and protobuf of the modified
+// vnodes, the read lock must be held.
+func (vt *VTree) refresh() {
+	vt.cacheLock.Lock()
+	defer vt.cacheLock.Unlock()
+	vt.Head.refresh()
 }
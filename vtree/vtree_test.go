@@ -1,17 +1,32 @@
 package vtree
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/ignore"
+	"github.com/orbit-drive/orbit-drive/ipfs"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/utils"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 const (
 	TESTDATA_DIRNAME = "testdata"
 
-	TESTDATA_ROOTHASH = "111664f60b22a9baef139fa783accae108e0a3ddd1403437c6d280b37f8ffbd7"
+	TESTDATA_ROOTHASH = "994e6aadcadd4145f0d86cad3dbbd1c56c99b85953fc2fc1447ba0d1cf6d5b70"
 )
 
 func setupTestVTree() (*VTree, error) {
@@ -20,7 +35,45 @@ func setupTestVTree() (*VTree, error) {
 		return nil, err
 	}
 	testDataPath := filepath.Join(path, TESTDATA_DIRNAME)
-	return NewVTree(testDataPath), nil
+	if err := pinTestData(testDataPath); err != nil {
+		return nil, err
+	}
+	return NewVTree(testDataPath, ipfs.NewMemStore()), nil
+}
+
+// pinTestData sets a fixed mode on the test data files, the permission
+// bits are hashed so the root hash would otherwise depend on the umask of
+// the checkout.
+func pinTestData(root string) error {
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		return os.Chmod(p, 0644)
+	})
+}
+
+func setupTestDb(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "orbit-drive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Db, err = leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		db.CloseDb()
+		os.RemoveAll(dir)
+	}
+}
+
+// drainState consumes the vtree state changes so vtree operations don't block.
+func drainState(vt *VTree) {
+	go func() {
+		for range vt.StateChanges() {
+		}
+	}()
 }
 
 func TestVTreeInit(t *testing.T) {
@@ -36,9 +89,14 @@ func TestVTreeInit(t *testing.T) {
 		t.Errorf("Expected %d vnodes, got: %d", 2, len(head.Links))
 	}
 
-	head.SortLinksByID()
-	folder1 := head.Links[0]
-	file1 := head.Links[1]
+	folder1, err := head.FindChildAt("folder1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file1, err := head.FindChildAt("file1")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if !folder1.IsDir() {
 		t.Error("folder1 should be a dir not a file.")
@@ -76,4 +134,1531 @@ func TestMerkleHash(t *testing.T) {
 		t.Errorf("Expected empty root hash got: %s", rootHash)
 	}
 
+	// Links order does not change the hash.
+	links := vt.Head.Links
+	for i, j := 0, len(links)-1; i < j; i, j = i+1, j-1 {
+		links[i], links[j] = links[j], links[i]
+	}
+	vt.Head.markDirty()
+	if rootHash = vt.MerkleHash(); rootHash != TESTDATA_ROOTHASH {
+		t.Errorf("Expected links order to keep root hash %s, got: %s", TESTDATA_ROOTHASH, rootHash)
+	}
+
+	// Renaming a file changes the hash, its own hash is kept.
+	file1, err := vt.Head.FindChildAt("file1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileHash := file1.MerkleHash()
+	file1.Relocate(vt.Head, "file3")
+	if vt.MerkleHash() == TESTDATA_ROOTHASH {
+		t.Error("Expected rename to change the root hash")
+	}
+	if file1.MerkleHash() != fileHash {
+		t.Errorf("Expected rename to keep the file hash %s, got: %s", fileHash, file1.MerkleHash())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	vt, err := setupTestVTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vt.PopulateNodes(make(db.Sources), false)
+	drainState(vt)
+
+	folder1Path := filepath.Join(vt.RootPath(), "folder1")
+	file2Path := filepath.Join(folder1Path, "file2")
+	file2, err := vt.Find(file2Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file2.Source.Save(file2.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	dirPaths, err := vt.Remove(folder1Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirPaths) != 1 || dirPaths[0] != folder1Path {
+		t.Errorf("Expected removed dir paths to be [%s], got: %v", folder1Path, dirPaths)
+	}
+	if vt.Head.LinksCount() != 1 {
+		t.Errorf("Expected %d vnode, got: %d", 1, vt.Head.LinksCount())
+	}
+	if _, err := vt.Find(file2Path); err != ErrVNodeNotFound {
+		t.Errorf("Expected %v, got: %v", ErrVNodeNotFound, err)
+	}
+	if _, err := db.Get(file2.ID); err != leveldb.ErrNotFound {
+		t.Errorf("Expected file2 source to be deleted, got: %v", err)
+	}
+
+	if _, err := vt.Remove(folder1Path); err != ErrVNodeNotFound {
+		t.Errorf("Expected %v, got: %v", ErrVNodeNotFound, err)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	vt := NewVTree(root, ipfs.NewMemStore())
+	vt.Build(make(db.Sources))
+	drainState(vt)
+
+	dir := filepath.Join(root, "archive")
+	nested := filepath.Join(dir, "a", "b")
+	os.MkdirAll(nested, os.ModePerm)
+	os.MkdirAll(filepath.Join(dir, "c"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(nested, "file1"), []byte("file1"), 0644)
+
+	dirPaths, err := vt.Add(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(dirPaths)
+	expected := []string{dir, filepath.Join(dir, "a"), nested, filepath.Join(dir, "c")}
+	if !reflect.DeepEqual(dirPaths, expected) {
+		t.Errorf("Expected created dir paths to be %v, got: %v", expected, dirPaths)
+	}
+	if _, err := vt.Find(filepath.Join(nested, "file1")); err != nil {
+		t.Errorf("Expected nested file to be added, got: %v", err)
+	}
+
+	dirPaths, err = vt.Add(filepath.Join(root, "file1"))
+	if err == nil || len(dirPaths) != 0 {
+		t.Errorf("Expected missing path not to be added, got: %v %v", dirPaths, err)
+	}
+	if vt.Head.LinksCount() != 1 {
+		t.Errorf("Expected %d vnode, got: %d", 1, vt.Head.LinksCount())
+	}
+}
+
+// failingStore is a MemStore failing to save the content while failing is set.
+type failingStore struct {
+	*ipfs.MemStore
+
+	failing bool
+}
+
+func (fs *failingStore) Put(r io.Reader) (string, error) {
+	if fs.failing {
+		return "", errors.New("store: unavailable")
+	}
+	return fs.MemStore.Put(r)
+}
+
+func TestUpdateUploadFailed(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	p := filepath.Join(root, "file1")
+	ioutil.WriteFile(p, []byte("file1"), 0644)
+	store := &failingStore{MemStore: ipfs.NewMemStore()}
+	vt := NewVTree(root, store)
+	vt.Build(make(db.Sources))
+	drainState(vt)
+	file1, _ := vt.Find(p)
+	uploaded := *file1.Source
+
+	// The source is kept until the content is uploaded.
+	store.failing = true
+	ioutil.WriteFile(p, []byte("file1 modified"), 0644)
+	if err := vt.Update(p); err == nil {
+		t.Errorf("Expected the failed upload to be returned")
+	}
+	if *file1.Source != uploaded {
+		t.Errorf("Expected source %+v to be kept, got: %+v", uploaded, *file1.Source)
+	}
+	store.failing = false
+	if err := vt.Update(p); err != nil {
+		t.Fatal(err)
+	}
+	if file1.Source.GetSrc() == "" || file1.Source.GetSrc() == uploaded.GetSrc() {
+		t.Errorf("Expected the modified content to be uploaded, got: %+v", *file1.Source)
+	}
+}
+
+func TestMove(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	vt, err := setupTestVTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vt.PopulateNodes(make(db.Sources), false)
+
+	states := []State{}
+	done := make(chan bool)
+	go func() {
+		for state := range vt.StateChanges() {
+			states = append(states, state)
+			done <- true
+		}
+	}()
+
+	oldPath := filepath.Join(vt.RootPath(), "folder1")
+	newPath := filepath.Join(vt.RootPath(), "folder2")
+	file2, err := vt.Find(filepath.Join(oldPath, "file2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldID := file2.ID
+	if err := file2.Source.Save(oldID); err != nil {
+		t.Fatal(err)
+	}
+
+	// A create is paired with a rename only if it holds the renamed vnode.
+	file1Path := filepath.Join(vt.RootPath(), "file1")
+	if vt.IsMoved(oldPath, file1Path) {
+		t.Error("Expected a file not to hold the renamed dir")
+	}
+	if !vt.IsMoved(filepath.Join(oldPath, "file2"), file1Path) {
+		t.Error("Expected a file with the same content to hold the renamed file")
+	}
+
+	oldDirPaths, newDirPaths, err := vt.Move(oldPath, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if len(oldDirPaths) != 1 || oldDirPaths[0] != oldPath {
+		t.Errorf("Expected old dir paths to be [%s], got: %v", oldPath, oldDirPaths)
+	}
+	if len(newDirPaths) != 1 || newDirPaths[0] != newPath {
+		t.Errorf("Expected new dir paths to be [%s], got: %v", newPath, newDirPaths)
+	}
+	if states[0].Op != MovedOp || states[0].OldPath != "folder1" || states[0].Path != "folder2" {
+		t.Errorf("Expected moved state from folder1 to folder2, got: %+v", states[0])
+	}
+
+	if _, err := vt.Find(filepath.Join(oldPath, "file2")); err != ErrVNodeNotFound {
+		t.Errorf("Expected %v, got: %v", ErrVNodeNotFound, err)
+	}
+	moved, err := vt.Find(filepath.Join(newPath, "file2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != file2 {
+		t.Error("Expected moved vnode to be the same vnode instance.")
+	}
+	if _, err := db.Get(oldID); err != leveldb.ErrNotFound {
+		t.Errorf("Expected old source key to be deleted, got: %v", err)
+	}
+	if _, err := db.Get(moved.ID); err != nil {
+		t.Errorf("Expected source to be saved under the new key, got: %v", err)
+	}
+
+	// The vnodes are linked back when the sources can not be re-keyed.
+	db.CloseDb()
+	if _, _, err := vt.Move(newPath, oldPath); err == nil {
+		t.Fatal("Expected move to fail with the db closed")
+	}
+	if _, err := vt.Find(filepath.Join(newPath, "file2")); err != nil {
+		t.Errorf("Expected file2 to stay in %s, got: %v", newPath, err)
+	}
+	if _, err := vt.Find(oldPath); err != ErrVNodeNotFound {
+		t.Errorf("Expected %v, got: %v", ErrVNodeNotFound, err)
+	}
+}
+
+func TestLoadVTree(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	vt, err := setupTestVTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVTree(vt.RootPath(), vt.Store()); err != ErrVTreeNotFound {
+		t.Errorf("Expected %v, got: %v", ErrVTreeNotFound, err)
+	}
+
+	vt.PopulateNodes(make(db.Sources), false)
+	if err := vt.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVTree(filepath.Dir(vt.RootPath()), vt.Store()); err != ErrVTreeNotFound {
+		t.Errorf("Expected %v for a different root path, got: %v", ErrVTreeNotFound, err)
+	}
+
+	loaded, err := LoadVTree(vt.RootPath(), vt.Store())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.MerkleHash() != vt.MerkleHash() {
+		t.Errorf("Expected merkle hash %s, got: %s", vt.MerkleHash(), loaded.MerkleHash())
+	}
+	file2, err := loaded.Find(filepath.Join(vt.RootPath(), "folder1", "file2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file2.IsDir() || file2.Source.Checksum == "" {
+		t.Errorf("Expected file2 to be a file with a checksum, got: %+v", file2)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeFile := func(p, content string) {
+		if err := ioutil.WriteFile(filepath.Join(root, p), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir(filepath.Join(root, "folder1"), 0755)
+	os.Mkdir(filepath.Join(root, "folder2"), 0755)
+	writeFile("file1", "file1")
+	writeFile("folder1/file2", "file2")
+	writeFile("folder1/file5", "file5")
+	writeFile("folder2/file7", "file7")
+	writeFile("folder1/file8", "file8")
+
+	vt := NewVTree(root, ipfs.NewMemStore())
+	vt.PopulateNodes(make(db.Sources), false)
+	if err := vt.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes while the vtree is not running.
+	os.Remove(filepath.Join(root, "file1"))
+	writeFile("folder1/file2", "file2 modified")
+	writeFile("folder1/file3", "file3")
+	os.Rename(filepath.Join(root, "folder1/file5"), filepath.Join(root, "file6"))
+	os.RemoveAll(filepath.Join(root, "folder2"))
+	writeFile("folder2", "folder2")
+	os.Chmod(filepath.Join(root, "folder1/file8"), 0755)
+
+	loaded, err := LoadVTree(root, vt.Store())
+	if err != nil {
+		t.Fatal(err)
+	}
+	states, err := loaded.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedStates := map[State]bool{
+		{Path: "file1", Op: RemovedOp}:                         true,
+		{Path: "folder1/file2", Op: ModifiedOp}:                true,
+		{Path: "folder1/file3", Op: AddedOp}:                   true,
+		{Path: "file6", Op: MovedOp, OldPath: "folder1/file5"}: true,
+		{Path: "folder2", Op: RemovedOp}:                       true,
+		{Path: "folder2", Op: AddedOp}:                         true,
+		{Path: "folder1/file8", Op: MetadataOp}:                true,
+	}
+	if len(states) != len(expectedStates) {
+		t.Errorf("Expected %d states, got: %v", len(expectedStates), states)
+	}
+	for _, state := range states {
+		if !expectedStates[state] {
+			t.Errorf("Unexpected state %+v", state)
+		}
+	}
+
+	expected := NewVTree(root, ipfs.NewMemStore())
+	expected.PopulateNodes(make(db.Sources), false)
+	if loaded.MerkleHash() != expected.MerkleHash() {
+		t.Errorf("Expected merkle hash %s, got: %s", expected.MerkleHash(), loaded.MerkleHash())
+	}
+	if _, err := loaded.Find(filepath.Join(root, "file1")); err != ErrVNodeNotFound {
+		t.Errorf("Expected %v, got: %v", ErrVNodeNotFound, err)
+	}
+	if _, err := loaded.Find(filepath.Join(root, "folder1", "file3")); err != nil {
+		t.Error(err)
+	}
+	// The dir replaced by a file keeps its id, the file source is saved.
+	folder2, err := loaded.Find(filepath.Join(root, "folder2"))
+	if err != nil || folder2.Type != FileCode {
+		t.Fatalf("Expected folder2 to be a file, got: %v", err)
+	}
+	if _, err := db.Get(folder2.ID); err != nil {
+		t.Errorf("Expected folder2 source to be saved, got: %v", err)
+	}
+}
+
+// blockingStore is a MemStore waiting for release to save the content.
+type blockingStore struct {
+	*ipfs.MemStore
+
+	uploading chan bool
+	release   chan bool
+}
+
+func (bs *blockingStore) Put(r io.Reader) (string, error) {
+	bs.uploading <- true
+	<-bs.release
+	return bs.MemStore.Put(r)
+}
+
+func TestReconcileUnlocked(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	store := &blockingStore{MemStore: ipfs.NewMemStore(), uploading: make(chan bool), release: make(chan bool)}
+	vt := NewVTree(root, store)
+	vt.PopulateNodes(make(db.Sources), false)
+
+	p := filepath.Join(root, "file1")
+	ioutil.WriteFile(p, []byte("file1"), 0644)
+	done := make(chan []State)
+	go func() {
+		states, err := vt.Reconcile()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- states
+	}()
+
+	// The vtree is readable while the file is uploaded.
+	<-store.uploading
+	found := make(chan error, 1)
+	go func() {
+		_, err := vt.Find(p)
+		found <- err
+	}()
+	select {
+	case err := <-found:
+		if err != ErrVNodeNotFound {
+			t.Errorf("Expected file1 to be linked once uploaded, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the vtree to be readable while uploading")
+	}
+	close(store.release)
+	states := <-done
+	if len(states) != 1 || states[0] != (State{Path: "file1", Op: AddedOp}) {
+		t.Errorf("Expected file1 to be added, got: %+v", states)
+	}
+	if vn, err := vt.Find(p); err != nil || !vn.Source.IsNew() {
+		t.Errorf("Expected file1 to be uploaded, got: %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	vt, err := setupTestVTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vt.Build(make(db.Sources))
+
+	dst, err := ioutil.TempDir("", "orbit-drive-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err := RestoreProto(vt.Store(), vt.ToProto().GetHead(), dst); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"file1", "folder1/file2"} {
+		expected, _ := ioutil.ReadFile(filepath.Join(vt.RootPath(), p))
+		content, err := ioutil.ReadFile(filepath.Join(dst, p))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(content) != string(expected) {
+			t.Errorf("Expected %s content %q, got: %q", p, expected, content)
+		}
+	}
+
+	file1, err := vt.Find(filepath.Join(vt.RootPath(), "file1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file1.Source.Checksum = "corrupted"
+	err = file1.Restore(filepath.Join(dst, "file1"))
+	if err != ErrChecksumMismatch {
+		t.Errorf("Expected %v, got: %v", ErrChecksumMismatch, err)
+	}
+}
+
+func TestRestorer(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	p := filepath.Join(root, "file1")
+	ioutil.WriteFile(p, []byte("synced"), 0644)
+
+	vt := NewVTree(root, ipfs.NewMemStore())
+	vt.Build(make(db.Sources))
+
+	r := &Restorer{Current: vt}
+	r.Restore(vt.Head, root)
+	if len(r.Results) != 1 || r.Results[0].Status != UpToDateStatus {
+		t.Errorf("Expected file1 to be %s, got: %+v", UpToDateStatus, r.Results)
+	}
+
+	ioutil.WriteFile(p, []byte("not synced"), 0644)
+	r = &Restorer{Current: vt}
+	r.Restore(vt.Head, root)
+	if r.Err() != ErrLocallyModified {
+		t.Errorf("Expected %v, got: %v", ErrLocallyModified, r.Err())
+	}
+
+	r = &Restorer{Current: vt, Force: true, DryRun: true}
+	r.Restore(vt.Head, root)
+	if r.Results[0].Status != DryRunStatus {
+		t.Errorf("Expected file1 to be %s, got: %s", DryRunStatus, r.Results[0].Status)
+	}
+	if content, _ := ioutil.ReadFile(p); string(content) != "not synced" {
+		t.Errorf("Expected dry run to leave file1 untouched, got: %q", content)
+	}
+
+	r = &Restorer{Current: vt, Force: true}
+	r.Restore(vt.Head, root)
+	if r.Err() != nil || r.Results[0].Status != RestoredStatus {
+		t.Errorf("Expected file1 to be %s, got: %+v", RestoredStatus, r.Results[0])
+	}
+	if content, _ := ioutil.ReadFile(p); string(content) != "synced" {
+		t.Errorf("Expected file1 content %q, got: %q", "synced", content)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	p := filepath.Join(root, "file1")
+	ioutil.WriteFile(p, []byte("version 1"), 0644)
+
+	vt := NewVTree(root, ipfs.NewMemStore())
+	vt.Build(make(db.Sources))
+	first, err := vt.SaveSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vt.SaveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(p, []byte("version 2"), 0644)
+	file1, _ := vt.Find(p)
+	file1.UpdateSource(db.NewSource(p))
+	second, err := vt.SaveSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Errorf("Expected %d snapshots, got: %d", 2, len(snapshots))
+	}
+	if found, err := FindSnapshot(first.GetMerkleHash()); err != nil || found.GetTimestamp() != first.GetTimestamp() {
+		t.Errorf("Expected to find first snapshot, got: %v", err)
+	}
+
+	versions, err := FileVersions("file1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[1].Src != file1.Source.GetSrc() {
+		t.Errorf("Expected 2 versions of file1, got: %+v", versions)
+	}
+
+	states := DiffSnapshots(first, second)
+	if len(states) != 1 || states[0] != (State{Path: "file1", Op: ModifiedOp}) {
+		t.Errorf("Expected file1 to be modified, got: %+v", states)
+	}
+
+	vt.Save()
+	SaveBase("peer", vt.ToProto())
+	SaveConflict(Conflict{Path: "file1", Timestamp: 1})
+	sources, err := db.GetSources(ReservedPrefixes()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 {
+		t.Errorf("Expected the vtree keys to be excluded from sources, got: %d sources", len(sources))
+	}
+}
+
+func TestGC(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	p := filepath.Join(root, "file1")
+	shared := filepath.Join(root, "file2")
+	ioutil.WriteFile(p, []byte("version 1"), 0644)
+	ioutil.WriteFile(shared, []byte("shared"), 0644)
+
+	store := ipfs.NewMemStore()
+	vt := NewVTree(root, store)
+	vt.Build(make(db.Sources))
+	vt.SaveSnapshot()
+	file1, _ := vt.Find(p)
+	file2, _ := vt.Find(shared)
+	pruned := []string{file1.Source.GetSrc()}
+
+	ioutil.WriteFile(p, []byte("version 2"), 0644)
+	file1.UpdateSource(db.NewSource(p))
+	vt.SaveSnapshot()
+	pruned = append(pruned, file1.Source.GetSrc())
+
+	// Replaced before being captured in a snapshot.
+	ioutil.WriteFile(p, []byte("version 2b"), 0644)
+	file1.UpdateSource(db.NewSource(p))
+	pruned = append(pruned, file1.Source.GetSrc())
+
+	ioutil.WriteFile(p, []byte("version 3"), 0644)
+	file1.UpdateSource(db.NewSource(p))
+	vt.SaveSnapshot()
+
+	policy := RetentionPolicy{KeepLast: 1}
+	report, err := vt.GC(policy, time.Now(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Pruned) != 2 || len(report.Unpinned) != 3 || report.Bytes != 28 {
+		t.Errorf("Expected 2 snapshots and 3 contents of 28 bytes to be reclaimed, got: %+v", report)
+	}
+	if snapshots, _ := Snapshots(); len(snapshots) != 3 {
+		t.Errorf("Expected dry run to keep %d snapshots, got: %d", 3, len(snapshots))
+	}
+
+	if _, err := vt.GC(policy, time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ := Snapshots(); len(snapshots) != 1 {
+		t.Errorf("Expected %d snapshot to be kept, got: %d", 1, len(snapshots))
+	}
+	for _, src := range pruned {
+		if ok, _ := store.Has(src); ok {
+			t.Errorf("Expected %s to be unpinned", src)
+		}
+	}
+	if ok, _ := store.Has(file2.Source.GetSrc()); !ok {
+		t.Errorf("Expected shared content %s to be kept", file2.Source.GetSrc())
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "node_modules", "pkg"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(root, "node_modules", "pkg", "index.js"), []byte("module"), 0644)
+	ioutil.WriteFile(filepath.Join(root, ".env"), []byte("secret"), 0644)
+	ioutil.WriteFile(filepath.Join(root, ".editorconfig"), []byte("root = true"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "file1.swp"), []byte("swap"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "file1"), []byte("file1"), 0644)
+	ioutil.WriteFile(filepath.Join(root, ignore.IGNOREFILENAME), []byte("node_modules/\n!.editorconfig\n"), 0644)
+
+	store := ipfs.NewMemStore()
+	vt := NewVTree(root, store)
+	vt.SetIgnorePatterns([]string{"*.swp"})
+	vt.Build(make(db.Sources))
+
+	expected := map[string]bool{
+		"node_modules":        false,
+		".env":                false,
+		ignore.IGNOREFILENAME: false,
+		"file1.swp":           false,
+		".editorconfig":       true,
+		"file1":               true,
+	}
+	for name, tracked := range expected {
+		if _, err := vt.Find(filepath.Join(root, name)); (err == nil) != tracked {
+			t.Errorf("Expected %s tracked to be %t", name, tracked)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(root, ignore.IGNOREFILENAME), []byte("file1\n"), 0644)
+	vt.ReloadIgnoreFile(root)
+	states, err := vt.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vt.Find(filepath.Join(root, "node_modules")); err != nil {
+		t.Errorf("Expected node_modules to be tracked once unignored, got: %v", err)
+	}
+	if _, err := vt.Find(filepath.Join(root, "file1")); err == nil {
+		t.Error("Expected file1 to be untracked once ignored")
+	}
+	if len(states) != 3 {
+		t.Errorf("Expected 3 state changes, got: %+v", states)
+	}
+	if !vt.IsIgnored(filepath.Join(root, "file1")) || vt.IsIgnored(filepath.Join(root, "node_modules")) {
+		t.Error("Expected IsIgnored to follow the reloaded patterns")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	p := filepath.Join(root, "script.sh")
+	ioutil.WriteFile(p, []byte("#!/bin/sh\necho orbit"), 0755)
+	os.Chmod(p, 0755)
+	modTime := time.Unix(1500000000, 0)
+	os.Chtimes(p, modTime, modTime)
+
+	vt := NewVTree(root, ipfs.NewMemStore())
+	vt.Build(make(db.Sources))
+	script, err := vt.Find(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewVTreeFromProto(vt.ToProto(), vt.Store()).Head.Links[0]
+	if !n.Source.IsExecutable() || n.Source.ModTime != modTime.UnixNano() {
+		t.Errorf("Expected mode and mod time to be kept in protobuf, got: %+v", n.Source)
+	}
+
+	dst, err := ioutil.TempDir("", "orbit-drive-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	restored := filepath.Join(dst, "script.sh")
+	if err := script.Restore(restored); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0755 || !fi.ModTime().Equal(modTime) {
+		t.Errorf("Expected restored mode %o and mod time %v, got: %o %v", 0755, modTime, fi.Mode().Perm(), fi.ModTime())
+	}
+
+	src, hash := script.Source.GetSrc(), script.MerkleHash()
+	os.Chmod(p, 0644)
+	if err := script.UpdateSource(db.NewSource(p)); err != nil {
+		t.Fatalf("Expected mode change to update the source, got: %v", err)
+	}
+	if script.Source.GetSrc() != src || script.Source.IsExecutable() {
+		t.Errorf("Expected mode only change to keep src %s, got: %+v", src, script.Source)
+	}
+	if script.MerkleHash() == hash {
+		t.Error("Expected mode change to change the merkle hash")
+	}
+	if err := script.UpdateSource(db.NewSource(p)); err != ErrIsUpToDate {
+		t.Errorf("Expected %v, got: %v", ErrIsUpToDate, err)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	outside, err := ioutil.TempFile("", "orbit-drive-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outside.Name())
+	os.Mkdir(filepath.Join(root, "dir"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(root, "file1"), []byte("file1"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "dir", "file2"), []byte("file2"), 0644)
+	os.Symlink("file1", filepath.Join(root, "link1"))
+	os.Symlink("dir", filepath.Join(root, "linkdir"))
+	os.Symlink(outside.Name(), filepath.Join(root, "outside"))
+	os.Symlink("..", filepath.Join(root, "dir", "loop"))
+
+	tests := []struct {
+		policy string
+		types  map[string]int
+	}{
+		{PreserveSymlinks, map[string]int{"link1": LinkCode, "linkdir": LinkCode, "outside": LinkCode, "dir/loop": LinkCode}},
+		{FollowSymlinks, map[string]int{"link1": FileCode, "linkdir": DirCode, "linkdir/file2": FileCode, "linkdir/loop": LinkCode, "outside": LinkCode, "dir/loop": LinkCode}},
+		{SkipSymlinks, map[string]int{"link1": -1, "linkdir": -1, "outside": -1, "dir/loop": -1}},
+	}
+	for _, test := range tests {
+		vt := NewVTree(root, ipfs.NewMemStore())
+		vt.SetSymlinkPolicy(test.policy)
+		vt.Build(make(db.Sources))
+		for name, code := range test.types {
+			vn, err := vt.Find(filepath.Join(root, name))
+			switch {
+			case code == -1 && err == nil:
+				t.Errorf("Expected %s to be skipped with %s policy", name, test.policy)
+			case code != -1 && err != nil:
+				t.Errorf("Expected %s to be found with %s policy, got: %v", name, test.policy, err)
+			case code != -1 && vn.Type != code:
+				t.Errorf("Expected %s type %d with %s policy, got: %d", name, code, test.policy, vn.Type)
+			}
+		}
+	}
+
+	vt := NewVTree(root, ipfs.NewMemStore())
+	vt.Build(make(db.Sources))
+	dst, err := ioutil.TempDir("", "orbit-drive-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	if err := RestoreProto(vt.Store(), vt.ToProto().GetHead(), dst); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"link1": "file1", "linkdir": "dir", "outside": outside.Name(), "dir/loop": ".."} {
+		if restored, err := os.Readlink(filepath.Join(dst, name)); err != nil || restored != target {
+			t.Errorf("Expected %s to be restored as a link to %s, got: %s %v", name, target, restored, err)
+		}
+	}
+}
+
+// toAbsPaths converts a tree to the absolute paths and ids saved before
+// the paths were made relative, returns the old ids mapped by new id.
+func toAbsPaths(n *pb.FSNode, root string, ids map[string][]byte) {
+	for _, link := range n.GetLinks() {
+		abs := filepath.Join(root, link.GetPath())
+		oldID := genChildID(n.GetID(), abs)
+		ids[utils.ToStr(link.GetID())] = oldID
+		link.ID, link.Path = oldID, abs
+		toAbsPaths(link, root, ids)
+	}
+}
+
+func TestMigratePaths(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	vt, err := setupTestVTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vt.PopulateNodes(make(db.Sources), false)
+	root := vt.RootPath()
+	file2, err := vt.Find(filepath.Join(root, "folder1", "file2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := proto.Clone(vt.ToProto()).(*pb.FSTree)
+	ids := make(map[string][]byte)
+	toAbsPaths(old.GetHead(), root, ids)
+	old.Root, old.Head.Path = "", root
+	oldID := ids[utils.ToStr(file2.ID)]
+	if err := file2.Source.Save(oldID); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := proto.Marshal(old)
+	db.Put(utils.ToByte(ROOTKEY), data)
+	data, _ = proto.Marshal(&pb.Snapshot{Timestamp: 1, MerkleHash: "hash", Tree: old})
+	db.Put(snapshotKey(1), data)
+
+	for i := 0; i < 2; i++ {
+		if err := MigratePaths(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := LoadVTree(root, vt.Store())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(loaded.ToProto(), vt.ToProto()) {
+		t.Errorf("Expected migrated vtree to equal %+v, got: %+v", vt.ToProto(), loaded.ToProto())
+	}
+	if _, err := db.Get(oldID); err != leveldb.ErrNotFound {
+		t.Errorf("Expected old source key to be deleted, got: %v", err)
+	}
+	if _, err := db.Get(file2.ID); err != nil {
+		t.Errorf("Expected source to be re-keyed, got: %v", err)
+	}
+
+	snapshot, err := FindSnapshot("hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(snapshot.GetTree(), vt.ToProto()) {
+		t.Errorf("Expected migrated snapshot tree to equal %+v, got: %+v", vt.ToProto(), snapshot.GetTree())
+	}
+}
+
+func TestMigrateSources(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.Mkdir(filepath.Join(root, "dir1"), 0755)
+	store := &failingStore{MemStore: ipfs.NewMemStore()}
+	// The ids were chained over the absolute paths and the sources had no metadata.
+	oldID := func(parentID []byte, p string) []byte {
+		return utils.HashBytes(append(append([]byte{}, parentID...), p...))
+	}
+	rootID := utils.ToByte(ROOTKEY)
+	dir1ID := oldID(rootID, filepath.Join(root, "dir1"))
+	oldIDs := map[string][]byte{
+		filepath.Join(root, "dir1", "file1"): oldID(dir1ID, filepath.Join(root, "dir1", "file1")),
+		filepath.Join(root, "file2"):         oldID(rootID, filepath.Join(root, "file2")),
+	}
+	srcs := make(map[string]string)
+	for p, id := range oldIDs {
+		ioutil.WriteFile(p, []byte(p), 0644)
+		source := db.NewSource(p)
+		srcs[p], _ = ipfs.UploadFile(store, p)
+		data, _ := json.Marshal(map[string]interface{}{"src": srcs[p], "size": source.Size, "checksum": source.Checksum})
+		db.Put(id, data)
+	}
+
+	if err := MigrateSources(root); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := db.GetSources(ReservedPrefixes()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The files are not uploaded again.
+	store.failing = true
+	vt := NewVTree(root, store)
+	vt.Build(sources)
+	sources.Dump()
+	for p, src := range srcs {
+		vn, err := vt.Find(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vn.Source.GetSrc() != src {
+			t.Errorf("Expected %s source %s to be migrated, got: %+v", p, src, vn.Source)
+		}
+		if _, err := db.Get(vn.ID); err != nil {
+			t.Errorf("Expected %s source to be saved, got: %v", p, err)
+		}
+	}
+	if left, _ := db.GetSources(ReservedPrefixes()...); len(left) != len(srcs) {
+		t.Errorf("Expected the old source keys to be deleted, got: %d sources", len(left))
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	root, err := ioutil.TempDir("", "orbit-drive-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	const writers, ops = 4, 20
+	for w := 0; w < writers; w++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir%d", w))
+		os.Mkdir(dir, 0755)
+		ioutil.WriteFile(filepath.Join(dir, "file"), []byte(dir), 0644)
+	}
+
+	vt := NewVTree(root, ipfs.NewMemStore())
+	if err := vt.Build(make(db.Sources)); err != nil {
+		t.Fatal(err)
+	}
+	drainState(vt)
+
+	var writing, reading sync.WaitGroup
+	errs := make(chan error, writers*ops)
+	for w := 0; w < writers; w++ {
+		writing.Add(1)
+		go func(dir string) {
+			defer writing.Done()
+			for i := 0; i < ops; i++ {
+				p := filepath.Join(dir, fmt.Sprintf("file%d", i))
+				ioutil.WriteFile(p, []byte(p), 0644)
+				if _, err := vt.Add(p); err != nil {
+					errs <- err
+				}
+				ioutil.WriteFile(p, []byte(p+" modified"), 0644)
+				if err := vt.Update(p); err != nil {
+					errs <- err
+				}
+				moved := p + ".moved"
+				os.Rename(p, moved)
+				if _, _, err := vt.Move(p, moved); err != nil {
+					errs <- err
+				}
+				if i%2 == 0 {
+					os.Remove(moved)
+					if _, err := vt.Remove(moved); err != nil {
+						errs <- err
+					}
+				}
+			}
+		}(filepath.Join(root, fmt.Sprintf("dir%d", w)))
+	}
+
+	done := make(chan bool)
+	for r := 0; r < writers; r++ {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				vt.Find(filepath.Join(root, "dir0", "file"))
+				vt.IsIgnored(filepath.Join(root, "dir1", "file"))
+				vt.MerkleHash()
+				vt.AllDirPaths()
+				if _, err := proto.Marshal(vt.ToProto()); err != nil {
+					errs <- err
+				}
+				if err := vt.Save(); err != nil {
+					errs <- err
+				}
+				if _, err := vt.SaveSnapshot(); err != nil {
+					errs <- err
+				}
+				// Pace the readers so they do not starve the writers on a single cpu.
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+	}
+	writing.Wait()
+	close(done)
+	reading.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	expected := NewVTree(root, ipfs.NewMemStore())
+	expected.PopulateNodes(make(db.Sources), false)
+	if vt.MerkleHash() != expected.MerkleHash() {
+		t.Errorf("Expected merkle hash %s after concurrent changes, got: %s", expected.MerkleHash(), vt.MerkleHash())
+	}
+}
+
+func TestDiff(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	newRoot := func() string {
+		root, err := ioutil.TempDir("", "orbit-drive-root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range []string{"dir1", "dir2", "dir3"} {
+			os.Mkdir(filepath.Join(root, dir), 0755)
+		}
+		for _, p := range []string{"file1", "file2", "script.sh", "replaced", "dir1/file3", "dir2/file4"} {
+			ioutil.WriteFile(filepath.Join(root, p), []byte(p), 0644)
+		}
+		return root
+	}
+	root := newRoot()
+	defer os.RemoveAll(root)
+	vt := NewVTree(root, ipfs.NewMemStore())
+	vt.Build(make(db.Sources))
+	drainState(vt)
+	before := vt.ToProto()
+
+	// A peer tree with the same content at another root has no difference.
+	peerRoot := newRoot()
+	defer os.RemoveAll(peerRoot)
+	peer := NewVTree(peerRoot, ipfs.NewMemStore())
+	peer.PopulateNodes(make(db.Sources), false)
+	if changes := vt.Diff(peer.ToProto()); len(changes) != 0 {
+		t.Errorf("Expected no changes with the peer tree, got: %+v", changes)
+	}
+
+	p := func(name string) string { return filepath.Join(root, name) }
+	ioutil.WriteFile(p("file1"), []byte("file1 modified"), 0644)
+	vt.Update(p("file1"))
+	os.Chmod(p("script.sh"), 0755)
+	vt.Update(p("script.sh"))
+	os.Rename(p("dir1"), p("dir3/moved"))
+	vt.Move(p("dir1"), p("dir3/moved"))
+	os.Remove(p("file2"))
+	vt.Remove(p("file2"))
+	ioutil.WriteFile(p("dir2/file5"), []byte("file5"), 0644)
+	vt.Add(p("dir2/file5"))
+	os.Remove(p("replaced"))
+	vt.Remove(p("replaced"))
+	os.Mkdir(p("replaced"), 0755)
+	vt.Add(p("replaced"))
+
+	expected := []State{
+		{Path: "dir2/file5", Op: AddedOp},
+		{Path: "dir3/moved", Op: MovedOp, OldPath: "dir1"},
+		{Path: "file1", Op: ModifiedOp},
+		{Path: "file2", Op: RemovedOp},
+		{Path: "replaced", Op: AddedOp},
+		{Path: "replaced", Op: RemovedOp},
+		{Path: "script.sh", Op: MetadataOp},
+	}
+	after := vt.ToProto()
+	states := []State{}
+	for _, change := range Diff(before, after) {
+		states = append(states, change.State)
+		if (change.Op != AddedOp && change.From == nil) || (change.Op != RemovedOp && change.To == nil) {
+			t.Errorf("Expected %s change of %s to hold its nodes", change.Op, change.Path)
+		}
+	}
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Path < states[j].Path || (states[i].Path == states[j].Path && states[i].Op < states[j].Op)
+	})
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("Expected changes %+v, got: %+v", expected, states)
+	}
+
+	// Trees hashed by another merkle scheme are hashed again.
+	old := proto.Clone(before).(*pb.FSTree)
+	old.MerkleAlgo = ""
+	old.Head.Links[0].MerkleHash = "outdated"
+	if changes := Diff(old, before); len(changes) != 0 {
+		t.Errorf("Expected no changes with the tree hashed again, got: %+v", changes)
+	}
+}
+
+func TestMergeApply(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	store := ipfs.NewMemStore()
+	newRoot := func() (string, *VTree) {
+		root, err := ioutil.TempDir("", "orbit-drive-root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range []string{"dir1", "dir2", "dir3"} {
+			os.Mkdir(filepath.Join(root, dir), 0755)
+		}
+		for _, p := range []string{"file1", "file2", "file3", "dir1/file4", "dir2/file5"} {
+			ioutil.WriteFile(filepath.Join(root, p), []byte(p), 0644)
+		}
+		vt := NewVTree(root, store)
+		vt.Build(make(db.Sources))
+		drainState(vt)
+		return root, vt
+	}
+	root, vt := newRoot()
+	defer os.RemoveAll(root)
+	peerRoot, peer := newRoot()
+	defer os.RemoveAll(peerRoot)
+	base := peer.ToProto()
+
+	p := func(root, name string) string { return filepath.Join(root, name) }
+	write := func(vt *VTree, name, content string) {
+		path := p(vt.RootPath(), name)
+		exists := utils.PathExists(path)
+		ioutil.WriteFile(path, []byte(content), 0644)
+		if exists {
+			vt.Update(path)
+			return
+		}
+		vt.Add(path)
+	}
+	// Remote changes.
+	write(peer, "file1", "file1 remote")
+	os.Remove(p(peerRoot, "file2"))
+	peer.Remove(p(peerRoot, "file2"))
+	os.Rename(p(peerRoot, "dir1"), p(peerRoot, "dir3/moved"))
+	peer.Move(p(peerRoot, "dir1"), p(peerRoot, "dir3/moved"))
+	os.Mkdir(p(peerRoot, "dir4"), 0755)
+	ioutil.WriteFile(p(peerRoot, "dir4/file6"), []byte("file6"), 0644)
+	peer.Add(p(peerRoot, "dir4"))
+	write(peer, "file3", "file3 remote")
+	// Local changes.
+	write(vt, "dir2/file5", "file5 local")
+	write(vt, "local", "local")
+	write(vt, "file3", "file3 local")
+
+	changes, conflicts := Merge(base, vt.ToProto(), peer.ToProto())
+	if len(conflicts) != 1 || conflicts[0].Path != "file3" || conflicts[0].Op != ModifiedOp {
+		t.Errorf("Expected file3 conflict, got: %+v", conflicts)
+	}
+	expected := []State{
+		{Path: "file2", Op: RemovedOp},
+		{Path: "dir3/moved", Op: MovedOp, OldPath: "dir1"},
+		{Path: "dir4", Op: AddedOp},
+		{Path: "file1", Op: ModifiedOp},
+	}
+	states := []State{}
+	for _, change := range changes {
+		states = append(states, change.State)
+	}
+	if !reflect.DeepEqual(states, expected) {
+		t.Fatalf("Expected changes %+v, got: %+v", expected, states)
+	}
+
+	for _, change := range changes {
+		if _, _, err := vt.Apply(change); err != nil {
+			t.Errorf("Apply %s %s: %v", change.Op, change.Path, err)
+		}
+	}
+	contents := map[string]string{
+		"file1":            "file1 remote",
+		"file3":            "file3 local",
+		"dir2/file5":       "file5 local",
+		"dir3/moved/file4": "dir1/file4",
+		"dir4/file6":       "file6",
+		"local":            "local",
+	}
+	for name, expected := range contents {
+		content, err := ioutil.ReadFile(p(root, name))
+		if err != nil || string(content) != expected {
+			t.Errorf("Expected %s content %q, got: %q (%v)", name, expected, content, err)
+		}
+		if _, err := vt.Find(p(root, name)); err != nil {
+			t.Errorf("Expected %s in the vtree, got: %v", name, err)
+		}
+	}
+	for _, name := range []string{"file2", "dir1"} {
+		if utils.PathExists(p(root, name)) {
+			t.Errorf("Expected %s to be removed", name)
+		}
+		if _, err := vt.Find(p(root, name)); err != ErrVNodeNotFound {
+			t.Errorf("Expected %s removed from the vtree, got: %v", name, err)
+		}
+	}
+	disk := NewVTree(root, store)
+	disk.PopulateNodes(make(db.Sources), false)
+	if vt.MerkleHash() != disk.MerkleHash() {
+		t.Errorf("Expected the vtree to match the disk")
+	}
+	if changes, _ := Merge(base, vt.ToProto(), peer.ToProto()); len(changes) != 0 {
+		t.Errorf("Expected applied changes to be merged, got: %+v", changes)
+	}
+
+	// The first merge keeps the local paths missing remotely.
+	changes, _ = Merge(nil, vt.ToProto(), peer.ToProto())
+	for _, change := range changes {
+		if change.Op == RemovedOp {
+			t.Errorf("Expected no removal without base, got: %+v", change.State)
+		}
+	}
+
+	// Files modified on disk since synced are not overwritten.
+	base = peer.ToProto()
+	write(peer, "file1", "file1 remote again")
+	ioutil.WriteFile(p(root, "file1"), []byte("file1 not synced"), 0644)
+	changes, _ = Merge(base, vt.ToProto(), peer.ToProto())
+	if len(changes) != 1 {
+		t.Fatalf("Expected file1 change, got: %+v", changes)
+	}
+	if _, _, err := vt.Apply(changes[0]); err != ErrLocallyModified {
+		t.Errorf("Expected %v, got: %v", ErrLocallyModified, err)
+	}
+	if content, _ := ioutil.ReadFile(p(root, "file1")); string(content) != "file1 not synced" {
+		t.Errorf("Expected file1 untouched, got: %q", content)
+	}
+
+	// Paths escaping the root are refused before anything is written.
+	remote := changes[0].To
+	escaping := []Change{
+		{State: State{Path: "../escaped", Op: AddedOp}, To: &pb.FSNode{Path: "../escaped", Source: remote.GetSource(), Size: remote.GetSize(), Checksum: remote.GetChecksum()}},
+		{State: State{Path: filepath.Join(filepath.Dir(root), "escaped"), Op: AddedOp}, To: &pb.FSNode{Path: "escaped", Source: remote.GetSource(), Size: remote.GetSize(), Checksum: remote.GetChecksum()}},
+		{State: State{Path: "escaped", Op: MovedOp, OldPath: "dir2/../../file1"}},
+		{State: State{Path: "dir5", Op: AddedOp}, To: &pb.FSNode{Path: "dir5", Type: pb.FSNode_DIR, Links: []*pb.FSNode{
+			{Path: "dir5/../../escaped", Source: remote.GetSource(), Size: remote.GetSize(), Checksum: remote.GetChecksum()},
+		}}},
+	}
+	defer os.Remove(filepath.Join(filepath.Dir(root), "escaped"))
+	for _, change := range escaping {
+		if _, _, err := vt.Apply(change); err != ErrNotInRoot {
+			t.Errorf("Expected %v applying %s, got: %v", ErrNotInRoot, change.Path, err)
+		}
+	}
+	for _, name := range []string{"../escaped", "escaped", "dir5"} {
+		if utils.PathExists(p(root, name)) {
+			t.Errorf("Expected %s not to be written", name)
+		}
+	}
+
+	// Paths ignored locally are not written.
+	vt.SetIgnorePatterns([]string{"node_modules"})
+	file := func(path string) *pb.FSNode {
+		return &pb.FSNode{Path: path, Source: remote.GetSource(), Size: remote.GetSize(), Checksum: remote.GetChecksum()}
+	}
+	ignored := Change{State: State{Path: "node_modules", Op: AddedOp}, To: &pb.FSNode{Path: "node_modules", Type: pb.FSNode_DIR, Links: []*pb.FSNode{
+		file("node_modules/file7"),
+	}}}
+	if _, _, err := vt.Apply(ignored); err != ErrIgnored {
+		t.Errorf("Expected %v, got: %v", ErrIgnored, err)
+	}
+	dir6 := Change{State: State{Path: "dir6", Op: AddedOp}, To: &pb.FSNode{Path: "dir6", Type: pb.FSNode_DIR, Links: []*pb.FSNode{
+		file("dir6/file8"),
+		{Path: "dir6/node_modules", Type: pb.FSNode_DIR, Links: []*pb.FSNode{file("dir6/node_modules/file9")}},
+	}}}
+	if _, _, err := vt.Apply(dir6); err != nil {
+		t.Fatal(err)
+	}
+	if !utils.PathExists(p(root, "dir6/file8")) {
+		t.Errorf("Expected dir6/file8 to be written")
+	}
+	for _, name := range []string{"node_modules", "dir6/node_modules"} {
+		if utils.PathExists(p(root, name)) {
+			t.Errorf("Expected %s not to be written", name)
+		}
+	}
+	if _, err := vt.Find(p(root, "dir6/node_modules")); err != ErrVNodeNotFound {
+		t.Errorf("Expected dir6/node_modules not to be linked, got: %v", err)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	date := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	paths := []struct {
+		path     string
+		isDir    bool
+		n        int
+		expected string
+	}{
+		{"dir/report.txt", false, 1, "dir/report (conflict from laptop 2019-03-14).txt"},
+		{"report.tar.gz", false, 2, "report.tar (conflict from laptop 2019-03-14 2).gz"},
+		{".bashrc", false, 1, ".bashrc (conflict from laptop 2019-03-14)"},
+		{"photos.d", true, 1, "photos.d (conflict from laptop 2019-03-14)"},
+	}
+	for _, p := range paths {
+		if path := ConflictPath(p.path, p.isDir, "laptop", date, p.n); path != p.expected {
+			t.Errorf("Expected %s conflict path %q, got: %q", p.path, p.expected, path)
+		}
+	}
+	if path := ConflictPath("dir/file", false, "../../lap/top\\", date, 1); path != "dir/file (conflict from laptop 2019-03-14)" {
+		t.Errorf("Expected the device name to be sanitized, got: %q", path)
+	}
+
+	store := ipfs.NewMemStore()
+	newRoot := func() (string, *VTree) {
+		root, err := ioutil.TempDir("", "orbit-drive-root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Mkdir(filepath.Join(root, "dir1"), 0755)
+		for _, p := range []string{"file1.txt", "file2", "file3", "dir1/file4"} {
+			ioutil.WriteFile(filepath.Join(root, p), []byte(p), 0644)
+		}
+		vt := NewVTree(root, store)
+		vt.Build(make(db.Sources))
+		drainState(vt)
+		return root, vt
+	}
+	root, vt := newRoot()
+	defer os.RemoveAll(root)
+	peerRoot, peer := newRoot()
+	defer os.RemoveAll(peerRoot)
+	base := peer.ToProto()
+
+	p := func(root, name string) string { return filepath.Join(root, name) }
+	write := func(vt *VTree, name, content string, modTime time.Time) {
+		path := p(vt.RootPath(), name)
+		ioutil.WriteFile(path, []byte(content), 0644)
+		os.Chtimes(path, modTime, modTime)
+		vt.Update(path)
+	}
+	now := time.Now()
+	// file1.txt is newer remotely, file2 is newer locally.
+	write(peer, "file1.txt", "file1 remote", now.Add(time.Hour))
+	write(vt, "file1.txt", "file1 local", now)
+	write(peer, "file2", "file2 remote", now)
+	write(vt, "file2", "file2 local", now.Add(time.Hour))
+	// file3 is removed remotely and modified locally.
+	os.Remove(p(peerRoot, "file3"))
+	peer.Remove(p(peerRoot, "file3"))
+	write(vt, "file3", "file3 local", now)
+	// dir1/file4 is modified remotely and removed locally.
+	write(peer, "dir1/file4", "file4 remote", now)
+	os.Remove(p(root, "dir1/file4"))
+	vt.Remove(p(root, "dir1/file4"))
+
+	changes, conflicts := Merge(base, vt.ToProto(), peer.ToProto())
+	if len(changes) != 0 || len(conflicts) != 4 {
+		t.Fatalf("Expected 4 conflicts, got: %+v %+v", changes, conflicts)
+	}
+
+	kept := func(resolved []Conflict) map[string]string {
+		k := make(map[string]string)
+		for _, c := range resolved {
+			k[c.Path] = c.Kept
+		}
+		return k
+	}
+	expected := map[string]string{"file1.txt": KeptLocal, "file2": KeptLocal, "file3": KeptLocal, "dir1/file4": KeptRemote}
+	if k := kept(vt.Resolve(conflicts, LocalPolicy, "peer", false, date)); !reflect.DeepEqual(k, expected) {
+		t.Errorf("Expected local policy to keep %v, got: %v", expected, k)
+	}
+	expected["file1.txt"] = KeptRemote
+	if k := kept(vt.Resolve(conflicts, NewestPolicy, "peer", false, date)); !reflect.DeepEqual(k, expected) {
+		t.Errorf("Expected newest policy to keep %v, got: %v", expected, k)
+	}
+
+	// A file already at the first conflict copy path of file2 is kept.
+	taken := p(root, ConflictPath("file2", false, "peer", date, 1))
+	ioutil.WriteFile(taken, []byte("taken"), 0644)
+	resolved := vt.Resolve(conflicts, "", "peer", false, date)
+	expected["file1.txt"], expected["file2"] = KeptBoth, KeptBoth
+	if k := kept(resolved); !reflect.DeepEqual(k, expected) {
+		t.Fatalf("Expected keep both policy to keep %v, got: %v", expected, k)
+	}
+	for _, c := range resolved {
+		for _, change := range c.Changes {
+			if _, _, err := vt.Apply(change); err != nil {
+				t.Errorf("Apply %s %s: %v", change.Op, change.Path, err)
+			}
+		}
+		if err := SaveConflict(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contents := map[string]string{
+		"file1.txt": "file1 local",
+		"file1 (conflict from peer 2019-03-14).txt": "file1 remote",
+		"file2":                                 "file2 local",
+		"file2 (conflict from peer 2019-03-14)": "taken",
+		"file2 (conflict from peer 2019-03-14 2)": "file2 remote",
+		"file3":      "file3 local",
+		"dir1/file4": "file4 remote",
+	}
+	for name, expected := range contents {
+		if content, err := ioutil.ReadFile(p(root, name)); err != nil || string(content) != expected {
+			t.Errorf("Expected %s content %q, got: %q (%v)", name, expected, content, err)
+		}
+	}
+
+	// The conflicts already resolved by a copy are skipped.
+	copied := []Change{}
+	for _, c := range conflicts {
+		if c.Path == "file1.txt" || c.Path == "file2" {
+			copied = append(copied, c)
+		}
+	}
+	if resolved := vt.Resolve(copied, KeepBothPolicy, "peer", false, date); len(resolved) != 0 {
+		t.Errorf("Expected resolved conflicts to be skipped, got: %+v", resolved)
+	}
+
+	saved, err := Conflicts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != len(resolved) {
+		t.Fatalf("Expected %d saved conflicts, got: %d", len(resolved), len(saved))
+	}
+	for i, c := range saved {
+		c.Changes = resolved[i].Changes
+		if !reflect.DeepEqual(c, resolved[i]) {
+			t.Errorf("Expected saved conflict %+v, got: %+v", resolved[i], c)
+		}
+	}
+}
+
+func TestConflictsConverge(t *testing.T) {
+	cleanup := setupTestDb(t)
+	defer cleanup()
+
+	date := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	store := ipfs.NewMemStore()
+	newDevice := func(name string) *VTree {
+		root, err := ioutil.TempDir("", "orbit-drive-root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(filepath.Join(root, "file1"), []byte("file1"), 0644)
+		vt := NewVTree(root, store)
+		vt.SetOwner(name)
+		vt.Build(make(db.Sources))
+		drainState(vt)
+		return vt
+	}
+	laptop, desktop := newDevice("laptop"), newDevice("desktop")
+	defer os.RemoveAll(laptop.RootPath())
+	defer os.RemoveAll(desktop.RootPath())
+	base := laptop.ToProto()
+	for _, vt := range []*VTree{laptop, desktop} {
+		p := filepath.Join(vt.RootPath(), "file1")
+		ioutil.WriteFile(p, []byte("file1 "+vt.Owner()), 0644)
+		vt.Update(p)
+	}
+
+	// Both devices pull at the same time, the laptop wins.
+	laptopTree, desktopTree := laptop.ToProto(), desktop.ToProto()
+	resolve := func(vt *VTree, remote *pb.FSTree, remoteWins bool) {
+		_, conflicts := Merge(base, vt.ToProto(), remote)
+		for _, c := range vt.Resolve(conflicts, KeepBothPolicy, remote.GetOwner(), remoteWins, date) {
+			for _, change := range c.Changes {
+				if _, _, err := vt.Apply(change); err != nil {
+					t.Errorf("Apply %s %s on %s: %v", change.Op, change.Path, vt.Owner(), err)
+				}
+			}
+		}
+	}
+	resolve(laptop, desktopTree, false)
+	resolve(desktop, laptopTree, true)
+
+	contents := map[string]string{
+		"file1": "file1 laptop",
+		"file1 (conflict from desktop 2019-03-14)": "file1 desktop",
+	}
+	for _, vt := range []*VTree{laptop, desktop} {
+		for name, expected := range contents {
+			content, err := ioutil.ReadFile(filepath.Join(vt.RootPath(), name))
+			if err != nil || string(content) != expected {
+				t.Errorf("Expected %s content %q on %s, got: %q (%v)", name, expected, vt.Owner(), content, err)
+			}
+		}
+	}
+	if laptop.MerkleHash() != desktop.MerkleHash() {
+		t.Errorf("Expected the devices to converge")
+	}
+	changes, conflicts := Merge(desktopTree, laptop.ToProto(), desktop.ToProto())
+	if len(changes) != 0 || len(conflicts) != 0 {
+		t.Errorf("Expected nothing to merge once converged, got: %+v %+v", changes, conflicts)
+	}
 }
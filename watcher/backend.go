@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// NotifyBackend watches the dirs with the os notifications (inotify, kqueue...).
+	NotifyBackend = "notify"
+
+	// PollBackend periodically scans the watched dirs.
+	PollBackend = "poll"
+)
+
+var (
+	// ErrUnknownBackend is returned when initializing a watcher with an unknown backend.
+	ErrUnknownBackend = errors.New("watcher: unknown backend")
+)
+
+// Backend represents a source of file system events for a list of
+// dirs, each dir is watched non recursively.
+type Backend interface {
+	// Add starts watching the dir.
+	Add(p string) error
+
+	// Remove stops watching the dir.
+	Remove(p string) error
+
+	// Events returns the channel the events are sent to.
+	Events() <-chan fsnotify.Event
+
+	// Errors returns the channel the errors are sent to.
+	Errors() <-chan error
+
+	// Close stops watching all the dirs.
+	Close() error
+}
+
+// notifyBackend is the fsnotify Backend.
+type notifyBackend struct {
+	*fsnotify.Watcher
+}
+
+// NewNotifyBackend initialize a Backend relying on the os notifications.
+func NewNotifyBackend() (Backend, error) {
+	n, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &notifyBackend{n}, nil
+}
+
+func (nb *notifyBackend) Events() <-chan fsnotify.Event {
+	return nb.Watcher.Events
+}
+
+func (nb *notifyBackend) Errors() <-chan error {
+	return nb.Watcher.Errors
+}
+
+// isNoSpace returns true if the error is caused by the exhaustion
+// of the os watches (max_user_watches).
+func isNoSpace(err error) bool {
+	if serr, ok := err.(*os.SyscallError); ok {
+		err = serr.Err
+	}
+	return err == syscall.ENOSPC
+}
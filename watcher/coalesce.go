@@ -0,0 +1,89 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pendingPath represents a path with events not handled yet.
+type pendingPath struct {
+	// last is the time of the latest event or size change of the path.
+	last time.Time
+
+	size    int64
+	modTime time.Time
+}
+
+// coalescer batches the events by path until the path has been quiet
+// for the window and its size stopped changing, so a single save or a
+// long copy is handled once.
+type coalescer struct {
+	window  time.Duration
+	pending map[string]*pendingPath
+}
+
+func newCoalescer(window time.Duration) *coalescer {
+	return &coalescer{
+		window:  window,
+		pending: make(map[string]*pendingPath),
+	}
+}
+
+// touch records an event of the path at the given time.
+func (c *coalescer) touch(p string, now time.Time) {
+	pp, ok := c.pending[p]
+	if !ok {
+		pp = &pendingPath{}
+		c.pending[p] = pp
+	}
+	pp.last = now
+	pp.stat(p)
+}
+
+// move transfers the pending events of the old path and all its
+// children to the new path.
+func (c *coalescer) move(oldPath, newPath string) {
+	for p, pp := range c.pending {
+		if p != oldPath && !strings.HasPrefix(p, oldPath+string(filepath.Separator)) {
+			continue
+		}
+		delete(c.pending, p)
+		c.pending[filepath.Join(newPath, strings.TrimPrefix(p, oldPath))] = pp
+	}
+}
+
+// ready removes and returns the pending paths quiet for the window
+// with a stable size, parent paths first.
+func (c *coalescer) ready(now time.Time) []string {
+	paths := []string{}
+	for p, pp := range c.pending {
+		if now.Sub(pp.last) < c.window {
+			continue
+		}
+		// Still growing, wait for the next window.
+		if pp.stat(p) {
+			pp.last = now
+			continue
+		}
+		delete(c.pending, p)
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// stat records the current size and modification time of the path,
+// returns true if they changed.
+func (pp *pendingPath) stat(p string) bool {
+	var size int64
+	var modTime time.Time
+	if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+		size, modTime = fi.Size(), fi.ModTime()
+	}
+	changed := size != pp.size || !modTime.Equal(pp.modTime)
+	pp.size, pp.modTime = size, modTime
+	return changed
+}
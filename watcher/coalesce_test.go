@@ -0,0 +1,48 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCoalescer(t *testing.T) {
+	root, err := ioutil.TempDir("", "orbit-drive-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	p := filepath.Join(root, "file1")
+	ioutil.WriteFile(p, []byte("part"), 0644)
+
+	window := 100 * time.Millisecond
+	c := newCoalescer(window)
+	now := time.Now()
+	c.touch(p, now)
+	c.touch(p, now.Add(window/2))
+	if paths := c.ready(now.Add(window)); len(paths) != 0 {
+		t.Errorf("Expected no paths before the quiet window, got: %v", paths)
+	}
+
+	// File keeps growing after the last event.
+	ioutil.WriteFile(p, []byte("partial content"), 0644)
+	now = now.Add(2 * window)
+	if paths := c.ready(now); len(paths) != 0 {
+		t.Errorf("Expected growing file to be delayed, got: %v", paths)
+	}
+	if paths := c.ready(now.Add(window)); len(paths) != 1 || paths[0] != p {
+		t.Errorf("Expected %s to be ready once stable, got: %v", p, paths)
+	}
+
+	dir := filepath.Join(root, "dir")
+	c.touch(dir, now)
+	c.touch(filepath.Join(dir, "file2"), now)
+	c.move(dir, filepath.Join(root, "moved"))
+	paths := c.ready(now.Add(window))
+	expected := []string{filepath.Join(root, "moved"), filepath.Join(root, "moved", "file2")}
+	if len(paths) != 2 || paths[0] != expected[0] || paths[1] != expected[1] {
+		t.Errorf("Expected %v, got: %v", expected, paths)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package watcher
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode returns the inode number of the file.
+func inode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,8 @@
+package watcher
+
+import "os"
+
+// inode returns 0, moves are not matched on windows.
+func inode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,257 @@
+package watcher
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// DefaultPollInterval is the default duration between two scans of the poll backend.
+	DefaultPollInterval = 2 * time.Second
+)
+
+var (
+	// ErrNotWatched is returned when removing a dir not watched.
+	ErrNotWatched = errors.New("watcher: path is not watched")
+)
+
+// fileMeta holds the metadata compared between two scans.
+type fileMeta struct {
+	size    int64
+	modTime time.Time
+	inode   uint64
+	mode    os.FileMode
+	isDir   bool
+}
+
+// pollBackend is the Backend scanning the watched dirs at an interval, for
+// the file systems without notifications (NFS, SSHFS...) or when the os
+// watches are exhausted. Files moved between two scans are matched by inode.
+// The changes are reported once unchanged for a scan, so a write spanning
+// two scans is reported once. Each scan is diffed against the previous scan
+// of the dir, not against the vtree: the first scan taken when the dir is
+// added is the baseline, the changes made before are left to the vtree
+// Reconcile run at start.
+type pollBackend struct {
+	sync.Mutex
+
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan bool
+	closed   bool
+
+	// dirs maps the watched dirs to the metadata of their entries by path
+	// as last reported.
+	dirs map[string]map[string]fileMeta
+
+	// scans maps the watched dirs to the metadata of their entries by path
+	// as last scanned.
+	scans map[string]map[string]fileMeta
+}
+
+// NewPollBackend initialize a Backend scanning the watched dirs at the
+// given interval, DefaultPollInterval is used if not positive.
+func NewPollBackend(interval time.Duration) Backend {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	pb := &pollBackend{
+		interval: interval,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan bool),
+		dirs:     make(map[string]map[string]fileMeta),
+		scans:    make(map[string]map[string]fileMeta),
+	}
+	go pb.run()
+	return pb
+}
+
+func (pb *pollBackend) Add(p string) error {
+	entries, err := scanDir(p)
+	if err != nil {
+		return err
+	}
+	pb.Lock()
+	defer pb.Unlock()
+	pb.dirs[filepath.Clean(p)] = entries
+	pb.scans[filepath.Clean(p)] = entries
+	return nil
+}
+
+func (pb *pollBackend) Remove(p string) error {
+	pb.Lock()
+	defer pb.Unlock()
+	p = filepath.Clean(p)
+	if _, ok := pb.dirs[p]; !ok {
+		return ErrNotWatched
+	}
+	delete(pb.dirs, p)
+	delete(pb.scans, p)
+	return nil
+}
+
+func (pb *pollBackend) Events() <-chan fsnotify.Event {
+	return pb.events
+}
+
+func (pb *pollBackend) Errors() <-chan error {
+	return pb.errors
+}
+
+func (pb *pollBackend) Close() error {
+	pb.Lock()
+	defer pb.Unlock()
+	if !pb.closed {
+		pb.closed = true
+		close(pb.done)
+	}
+	return nil
+}
+
+func (pb *pollBackend) run() {
+	ticker := time.NewTicker(pb.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, e := range pb.poll() {
+				select {
+				case pb.events <- e:
+				case <-pb.done:
+					return
+				}
+			}
+		case <-pb.done:
+			return
+		}
+	}
+}
+
+// poll scans all the watched dirs and returns the events of the
+// differences settled since the previous report.
+func (pb *pollBackend) poll() []fsnotify.Event {
+	pb.Lock()
+	defer pb.Unlock()
+
+	created := make(map[string]fileMeta)
+	removed := make(map[string]fileMeta)
+	events := []fsnotify.Event{}
+	for dir, prev := range pb.dirs {
+		scanned, err := scanDir(dir)
+		if err != nil {
+			// Removed dirs are reported by the scan of their parent.
+			delete(pb.dirs, dir)
+			delete(pb.scans, dir)
+			continue
+		}
+		entries := settle(prev, pb.scans[dir], scanned)
+		pb.scans[dir], pb.dirs[dir] = scanned, entries
+
+		for p, meta := range entries {
+			old, ok := prev[p]
+			switch {
+			case !ok:
+				created[p] = meta
+			case old.inode != meta.inode || old.isDir != meta.isDir:
+				removed[p] = old
+				created[p] = meta
+			case !meta.isDir && (old.size != meta.size || !old.modTime.Equal(meta.modTime)):
+				events = append(events, fsnotify.Event{Name: p, Op: fsnotify.Write})
+			case old.mode != meta.mode:
+				events = append(events, fsnotify.Event{Name: p, Op: fsnotify.Chmod})
+			}
+		}
+		for p, old := range prev {
+			if _, ok := entries[p]; !ok {
+				removed[p] = old
+			}
+		}
+	}
+
+	// A rename is followed by the create of the new path.
+	moved := make(map[uint64]string)
+	for p, meta := range removed {
+		if meta.inode != 0 {
+			moved[meta.inode] = p
+		}
+	}
+	for _, p := range sortedPaths(created) {
+		oldPath, ok := moved[created[p].inode]
+		if ok && oldPath != p {
+			delete(moved, created[p].inode)
+			delete(removed, oldPath)
+			events = append(events, fsnotify.Event{Name: oldPath, Op: fsnotify.Rename})
+		}
+		events = append(events, fsnotify.Event{Name: p, Op: fsnotify.Create})
+	}
+	for _, p := range sortedPaths(removed) {
+		events = append(events, fsnotify.Event{Name: p, Op: fsnotify.Remove})
+	}
+	return events
+}
+
+// settle returns the reported entries updated with the scanned entries
+// unchanged since the last scan, the others keep their reported state
+// until they settle.
+func settle(reported, last, scanned map[string]fileMeta) map[string]fileMeta {
+	entries := make(map[string]fileMeta)
+	for p, meta := range scanned {
+		if old, ok := last[p]; ok && old.equal(meta) {
+			entries[p] = meta
+		} else if old, ok := reported[p]; ok {
+			entries[p] = old
+		}
+	}
+	for p, old := range reported {
+		if _, ok := scanned[p]; !ok {
+			if _, ok := last[p]; ok {
+				// Removed since the last scan only.
+				entries[p] = old
+			}
+		}
+	}
+	return entries
+}
+
+// equal returns true if both metadata are the same.
+func (m fileMeta) equal(other fileMeta) bool {
+	return m.size == other.size && m.modTime.Equal(other.modTime) && m.inode == other.inode &&
+		m.mode == other.mode && m.isDir == other.isDir
+}
+
+// scanDir returns the metadata of the dir entries by path.
+func scanDir(dir string) (map[string]fileMeta, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]fileMeta)
+	for _, f := range files {
+		entries[filepath.Join(dir, f.Name())] = fileMeta{
+			size:    f.Size(),
+			modTime: f.ModTime(),
+			inode:   inode(f),
+			mode:    f.Mode(),
+			isDir:   f.IsDir(),
+		}
+	}
+	return entries, nil
+}
+
+func sortedPaths(entries map[string]fileMeta) []string {
+	paths := []string{}
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
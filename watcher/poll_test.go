@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// nextEvents collects the events of the backend until it is quiet.
+func nextEvents(b Backend) []fsnotify.Event {
+	events := []fsnotify.Event{}
+	for {
+		select {
+		case e := <-b.Events():
+			events = append(events, e)
+		case <-time.After(100 * time.Millisecond):
+			return events
+		}
+	}
+}
+
+func TestPollBackend(t *testing.T) {
+	root, err := ioutil.TempDir("", "orbit-drive-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "dir")
+	os.Mkdir(dir, os.ModePerm)
+
+	b := NewPollBackend(10 * time.Millisecond)
+	defer b.Close()
+	if err := b.Add(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	p := filepath.Join(root, "file1")
+	ioutil.WriteFile(p, []byte("file1"), 0644)
+	events := nextEvents(b)
+	if len(events) != 1 || events[0] != (fsnotify.Event{Name: p, Op: fsnotify.Create}) {
+		t.Errorf("Expected create of %s, got: %v", p, events)
+	}
+
+	ioutil.WriteFile(p, []byte("file1 modified"), 0644)
+	events = nextEvents(b)
+	if len(events) != 1 || events[0] != (fsnotify.Event{Name: p, Op: fsnotify.Write}) {
+		t.Errorf("Expected write of %s, got: %v", p, events)
+	}
+
+	os.Chmod(p, 0755)
+	events = nextEvents(b)
+	if len(events) != 1 || events[0] != (fsnotify.Event{Name: p, Op: fsnotify.Chmod}) {
+		t.Errorf("Expected chmod of %s, got: %v", p, events)
+	}
+
+	moved := filepath.Join(dir, "file1")
+	os.Rename(p, moved)
+	events = nextEvents(b)
+	expected := []fsnotify.Event{{Name: p, Op: fsnotify.Rename}, {Name: moved, Op: fsnotify.Create}}
+	if len(events) != 2 || events[0] != expected[0] || events[1] != expected[1] {
+		t.Errorf("Expected %v, got: %v", expected, events)
+	}
+
+	os.Remove(moved)
+	events = nextEvents(b)
+	if len(events) != 1 || events[0] != (fsnotify.Event{Name: moved, Op: fsnotify.Remove}) {
+		t.Errorf("Expected remove of %s, got: %v", moved, events)
+	}
+
+	if err := b.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove(dir); err != ErrNotWatched {
+		t.Errorf("Expected %v, got: %v", ErrNotWatched, err)
+	}
+}
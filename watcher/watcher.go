@@ -1,15 +1,29 @@
 package watcher
 
 import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
 	"github.com/fsnotify/fsnotify"
-	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/ignore"
 	"github.com/orbit-drive/orbit-drive/sys"
-	"github.com/orbit-drive/orbit-drive/utils"
 	"github.com/orbit-drive/orbit-drive/vtree"
 	log "github.com/sirupsen/logrus"
 )
 
-// Watcher is a wrapper to fsnotify watcher and represents
+const (
+	// moveWindow is the max duration to wait for the create event matching
+	// a rename event before the rename is treated as a remove.
+	moveWindow = 100 * time.Millisecond
+
+	// DefaultQuietWindow is the default duration without events on a path
+	// before its events are handled.
+	DefaultQuietWindow = 500 * time.Millisecond
+)
+
+// Watcher is a wrapper to a watcher Backend and represents
 // a path to watch for usr changes.
 type Watcher struct {
 	// Done channel used to indicate when to stop the watcher
@@ -18,20 +32,63 @@ type Watcher struct {
 	// Path is absolute path to watch.
 	Path string
 
-	// Notifier holds the fs watcher
-	Notifier *fsnotify.Watcher
+	// Backend holds the fs watcher.
+	Backend Backend
+
+	// QuietWindow is the duration without events on a path before its events are handled.
+	QuietWindow time.Duration
+
+	// PollInterval is the duration between two scans of the poll backend.
+	PollInterval time.Duration
+
+	// watched holds the dirs added to the backend.
+	watched map[string]bool
+
+	// pending holds the events not handled yet.
+	pending *coalescer
+
+	// suppressed maps the paths written by Write to the time their events
+	// are handled again, zero while written.
+	suppressed map[string]time.Time
+
+	// ops holds the functions to run in the Start loop.
+	ops chan func()
 }
 
-// NewWatcher initialize a new Watcher.
-func NewWatcher(p string) (*Watcher, error) {
-	n, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, err
+// WriteFunc writes paths to disk outside of the watcher and returns the
+// dir paths added and removed.
+type WriteFunc func() ([]string, []string, error)
+
+// NewWatcher initialize a new Watcher with the given backend, quiet window
+// and poll interval. The notify backend falls back to the poll backend
+// when the os watches are exhausted.
+func NewWatcher(p, backend string, quietWindow, pollInterval time.Duration) (*Watcher, error) {
+	var b Backend
+	switch backend {
+	case PollBackend:
+		b = NewPollBackend(pollInterval)
+	case NotifyBackend, "":
+		n, err := NewNotifyBackend()
+		if err != nil {
+			return nil, err
+		}
+		b = n
+	default:
+		return nil, ErrUnknownBackend
+	}
+	if quietWindow <= 0 {
+		quietWindow = DefaultQuietWindow
 	}
 	w := &Watcher{
-		Done:     make(chan bool),
-		Path:     p,
-		Notifier: n,
+		Done:         make(chan bool),
+		Path:         p,
+		Backend:      b,
+		QuietWindow:  quietWindow,
+		PollInterval: pollInterval,
+		watched:      make(map[string]bool),
+		pending:      newCoalescer(quietWindow),
+		suppressed:   make(map[string]time.Time),
+		ops:          make(chan func()),
 	}
 	w.AddToWatchList(w.Path)
 	return w, nil
@@ -39,8 +96,27 @@ func NewWatcher(p string) (*Watcher, error) {
 
 // AddToWatchList adds path to watch.
 func (w *Watcher) AddToWatchList(p string) {
-	if err := w.Notifier.Add(p); err != nil {
-		w.Notifier.Errors <- err
+	err := w.Backend.Add(p)
+	if isNoSpace(err) {
+		log.WithField("path", p).Warn("Out of os watches, falling back to polling")
+		w.fallbackToPoll()
+		err = w.Backend.Add(p)
+	}
+	if err != nil {
+		sys.Alert(err.Error())
+		return
+	}
+	w.watched[p] = true
+}
+
+// fallbackToPoll replaces the backend by a poll backend watching the same dirs.
+func (w *Watcher) fallbackToPoll() {
+	w.Backend.Close()
+	w.Backend = NewPollBackend(w.PollInterval)
+	for p := range w.watched {
+		if err := w.Backend.Add(p); err != nil {
+			log.WithField("path", p).Debug(err)
+		}
 	}
 }
 
@@ -52,35 +128,81 @@ func (w *Watcher) BatchAdd(paths []string) {
 	}
 }
 
-// RemoveFromWatchList traverse a path and remove all nested dir
-// path from the notifier watch list.
+// RemoveFromWatchList removes path from the notifier watch list.
 func (w *Watcher) RemoveFromWatchList(p string) {
-	if err := w.Notifier.Remove(p); err != nil {
-		w.Notifier.Errors <- err
+	// Removed dirs are usually already dropped by the backend,
+	// so a failure here is only logged.
+	delete(w.watched, p)
+	if err := w.Backend.Remove(p); err != nil {
+		log.WithField("path", p).Debug(err)
+	}
+}
+
+// BatchRemove removes multiple paths from watcher.
+func (w *Watcher) BatchRemove(paths []string) {
+	for _, path := range paths {
+		w.RemoveFromWatchList(path)
+		log.WithField("path", path).Info("Path removed from watcher!")
 	}
 }
 
 // Start initialize watcher notifier and check for the notifier
-// Event channel and Errors channel.
+// Event channel and Errors channel. Events are coalesced by path and
+// handled once the path has been quiet for the QuietWindow.
 func (w *Watcher) Start(vt *vtree.VTree) {
+	// A move is notified as a rename of the old path followed by a create
+	// of the new path, renamed holds the old path until the create of a path
+	// holding the same vnode arrives.
+	var renamed string
+	var renameTimeout <-chan time.Time
+
+	ticker := time.NewTicker(w.QuietWindow / 2)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case e := <-w.Notifier.Events:
-			if !validEvent(e) {
+		case e := <-w.Backend.Events():
+			if w.isSuppressed(e.Name, time.Now()) {
+				// Handled once written, local edits are kept.
+				w.pending.touch(e.Name, time.Now())
 				continue
 			}
-			switch e.Op {
-			case fsnotify.Create:
-				createHandler(w, vt, e.Name)
-			case fsnotify.Write:
-				writeHandler(w, vt, e.Name)
-			case fsnotify.Remove: // manually remove from folder triggers fsnotify.Rename
-				removeHandler(w, vt, e.Name)
-			default:
-				log.WithField("event", e.String()).Warn("Watcher unhandled event")
+			if filepath.Base(e.Name) == ignore.IGNOREFILENAME {
+				ignoreHandler(w, vt, e.Name)
 				continue
 			}
-		case err := <-w.Notifier.Errors:
+			if !validEvent(vt, e) {
+				continue
+			}
+			if renamed != "" && (e.Op&fsnotify.Create == 0 || !vt.IsMoved(renamed, e.Name)) {
+				w.pending.touch(renamed, time.Now())
+				renamed, renameTimeout = "", nil
+			}
+			switch {
+			case e.Op&fsnotify.Rename != 0: // manually remove from folder triggers fsnotify.Rename
+				renamed, renameTimeout = e.Name, time.After(moveWindow)
+			case e.Op&fsnotify.Create != 0 && renamed != "":
+				moveHandler(w, vt, renamed, e.Name)
+				renamed, renameTimeout = "", nil
+			default:
+				w.pending.touch(e.Name, time.Now())
+			}
+		case <-renameTimeout:
+			// Path was moved out of the watched folder.
+			w.pending.touch(renamed, time.Now())
+			renamed, renameTimeout = "", nil
+		case now := <-ticker.C:
+			for _, p := range w.pending.ready(now) {
+				if w.isSuppressed(p, now) {
+					// Handled once written.
+					w.pending.touch(p, now)
+					continue
+				}
+				syncHandler(w, vt, p)
+			}
+		case op := <-w.ops:
+			op()
+		case err := <-w.Backend.Errors():
 			sys.Alert(err.Error())
 		case <-w.Done:
 			return
@@ -90,46 +212,190 @@ func (w *Watcher) Start(vt *vtree.VTree) {
 
 // Stop close the fs watcher and triggers the Done channel.
 func (w *Watcher) Stop() {
-	w.Notifier.Close()
+	w.Backend.Close()
 	w.Done <- true
 }
 
+// Write calls fn while the events of the paths and their children are
+// suppressed, so the files written from the peers are not handled as local
+// changes. The events notified until the quiet window after fn returns are
+// dropped too, the dirs added by fn are then watched and the removed dirs
+// unwatched. The watcher must be started.
+func (w *Watcher) Write(fn WriteFunc, paths ...string) error {
+	w.do(func() {
+		for _, p := range paths {
+			w.suppressed[p] = time.Time{}
+		}
+	})
+	added, removed, err := fn()
+	w.do(func() {
+		w.BatchRemove(removed)
+		w.BatchAdd(added)
+		until := time.Now().Add(w.suppressWindow())
+		for _, p := range paths {
+			w.suppressed[p] = until
+		}
+	})
+	return err
+}
+
+// do runs fn in the Start loop and waits for it to return.
+func (w *Watcher) do(fn func()) {
+	done := make(chan bool)
+	w.ops <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+}
+
+// isSuppressed returns true if the events of the path or of one of its
+// parent dirs are suppressed at the given time, expired paths are dropped.
+func (w *Watcher) isSuppressed(p string, now time.Time) bool {
+	for sp, until := range w.suppressed {
+		if !until.IsZero() && now.After(until) {
+			delete(w.suppressed, sp)
+			continue
+		}
+		if p == sp || strings.HasPrefix(p, sp+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// suppressWindow returns the max delay between a write and its last event.
+func (w *Watcher) suppressWindow() time.Duration {
+	d := w.QuietWindow + moveWindow
+	if pb, ok := w.Backend.(*pollBackend); ok {
+		// Changes are reported once unchanged for a scan.
+		d += 2 * pb.interval
+	}
+	return d
+}
+
+// syncHandler compares the path on disk with the vtree once its events
+// are coalesced: create+write is an add, write+remove is a remove and
+// remove+create is a write.
+func syncHandler(w *Watcher, vt *vtree.VTree, p string) {
+	code, err := vt.PathType(p)
+	vn, findErr := vt.Find(p)
+	switch {
+	case err != nil && findErr != nil:
+		// Created and removed before being handled.
+	case err != nil:
+		removeHandler(w, vt, p)
+	case findErr != nil:
+		createHandler(w, vt, p)
+	case vn.Type != code:
+		removeHandler(w, vt, p)
+		createHandler(w, vt, p)
+	case code != vtree.DirCode:
+		writeHandler(w, vt, p)
+	}
+}
+
 func createHandler(w *Watcher, vt *vtree.VTree, p string) {
 	log.WithField("path", p).Info("Watcher detected file op: create")
-	if err := vt.Add(p); err != nil {
+	dirPaths, err := vt.Add(p)
+	if err != nil {
 		sys.Alert(err.Error())
 		return
 	}
-	if isDir, _ := utils.IsDir(p); isDir {
-		w.AddToWatchList(p) // TODO: Figure out how to get all new dir paths from vt.Add
+	w.BatchAdd(dirPaths)
+	// Paths created before their dir was watched are never notified.
+	for _, dir := range dirPaths {
+		w.rescan(vt, dir)
 	}
 }
 
 func writeHandler(w *Watcher, vt *vtree.VTree, p string) {
 	log.WithField("path", p).Info("Watcher detected file op: write")
-	vn, err := vt.Find(p)
+	if err := vt.Update(p); err != nil && err != vtree.ErrIsUpToDate {
+		sys.Alert(err.Error())
+	}
+}
+
+func removeHandler(w *Watcher, vt *vtree.VTree, p string) {
+	log.WithField("path", p).Info("Watcher detected file op: remove")
+	dirPaths, err := vt.Remove(p)
 	if err != nil {
 		sys.Alert(err.Error())
 		return
 	}
-	source := db.NewSource(p)
-	if err := vn.UpdateSource(source); err != nil {
-		log.Warn(err)
+	w.BatchRemove(dirPaths)
+}
+
+func moveHandler(w *Watcher, vt *vtree.VTree, oldPath, newPath string) {
+	log.WithFields(log.Fields{
+		"old-path": oldPath,
+		"new-path": newPath,
+	}).Info("Watcher detected file op: move")
+	oldDirPaths, newDirPaths, err := vt.Move(oldPath, newPath)
+	if err == vtree.ErrVNodeNotFound {
+		// Path moved in from outside of the vtree or not handled yet.
+		w.pending.move(oldPath, newPath)
+		w.pending.touch(newPath, time.Now())
+		return
 	}
-	vt.PushToState(vn.Path, vtree.ModifiedOp)
+	if err != nil {
+		sys.Alert(err.Error())
+		return
+	}
+	// Events not handled yet follow the moved path.
+	w.pending.move(oldPath, newPath)
+	w.BatchRemove(oldDirPaths)
+	w.BatchAdd(newDirPaths)
 }
 
-func removeHandler(w *Watcher, vt *vtree.VTree, p string) {
-	log.WithField("path", p).Info("Watcher detected file op: remove")
-	vt.Remove(p)
-	if isDir, _ := utils.IsDir(p); isDir {
-		w.RemoveFromWatchList(p) // TODO: Figure out how to get all dir paths removed from vt.Remove
+// rescan compares the dir on disk with the vtree and queues the paths
+// missing from the vtree or with a different size.
+func (w *Watcher) rescan(vt *vtree.VTree, dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.WithField("path", dir).Debug(err)
+		return
+	}
+	now := time.Now()
+	for _, f := range files {
+		p := filepath.Join(dir, f.Name())
+		if vt.IsIgnored(p) {
+			continue
+		}
+		vn, err := vt.Find(p)
+		if err != nil || (!f.IsDir() && vn.Source != nil && vn.Source.Size != f.Size()) {
+			w.pending.touch(p, now)
+		}
+	}
+}
+
+// ignoreHandler reloads the changed .orbitignore file and reconciles the
+// vtree so newly ignored paths are removed and unignored paths are added.
+func ignoreHandler(w *Watcher, vt *vtree.VTree, p string) {
+	log.WithField("path", p).Info("Watcher detected ignore file change")
+	vt.ReloadIgnoreFile(filepath.Dir(p))
+	states, err := vt.Reconcile()
+	if err != nil {
+		sys.Alert(err.Error())
+		return
+	}
+	for _, state := range states {
+		p := filepath.Join(vt.RootPath(), state.Path)
+		switch state.Op {
+		case vtree.AddedOp, vtree.MovedOp:
+			if vn, err := vt.Find(p); err == nil {
+				w.BatchAdd(vn.AllDirPaths())
+			}
+		case vtree.RemovedOp:
+			w.RemoveFromWatchList(p)
+		}
 	}
+	go vt.PushStates(states)
 }
 
-func validEvent(e fsnotify.Event) bool {
+func validEvent(vt *vtree.VTree, e fsnotify.Event) bool {
 	if e.Op.String() == "" {
 		return false
 	}
-	return !utils.IsHidden(e.Name)
+	return !vt.IsIgnored(e.Name)
 }
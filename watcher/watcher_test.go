@@ -0,0 +1,106 @@
+package watcher
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/orbit-drive/orbit-drive/db"
+	"github.com/orbit-drive/orbit-drive/ipfs"
+	"github.com/orbit-drive/orbit-drive/pb"
+	"github.com/orbit-drive/orbit-drive/vtree"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// remoteFile uploads the content to the store and returns the change
+// adding it at path as received from a peer.
+func remoteFile(t *testing.T, store ipfs.ContentStore, path, content string) vtree.Change {
+	cid, err := store.Put(bytes.NewBufferString(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum := md5.Sum([]byte(content))
+	return vtree.Change{
+		State: vtree.State{Path: path, Op: vtree.AddedOp},
+		To: &pb.FSNode{
+			Path:     path,
+			Type:     pb.FSNode_FILE,
+			Source:   cid,
+			Size:     int64(len(content)),
+			Checksum: hex.EncodeToString(checksum[:]),
+			Mode:     0644,
+		},
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orbit-drive-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if db.Db, err = leveldb.OpenFile(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.CloseDb()
+
+	root, err := ioutil.TempDir("", "orbit-drive-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	store := ipfs.NewMemStore()
+	vt := vtree.NewVTree(root, store)
+	vt.Build(make(db.Sources))
+	w, err := NewWatcher(root, PollBackend, 20*time.Millisecond, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go w.Start(vt)
+	defer w.Stop()
+
+	// The remote dir is written with its file in a single change.
+	change := remoteFile(t, store, "remote/file1", "file1")
+	change.State.Path = "remote"
+	change.To = &pb.FSNode{Path: "remote", Type: pb.FSNode_DIR, Links: []*pb.FSNode{change.To}}
+	err = w.Write(func() ([]string, []string, error) {
+		return vt.Apply(change)
+	}, filepath.Join(root, "remote"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(root, "remote/file1")); string(content) != "file1" {
+		t.Errorf("Expected remote/file1 content %q, got: %q", "file1", content)
+	}
+
+	expectState := func(expected vtree.State) {
+		select {
+		case state := <-vt.StateChanges():
+			if state != expected {
+				t.Errorf("Expected state %+v, got: %+v", expected, state)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected state %+v", expected)
+		}
+	}
+
+	// Local edits made while the events are suppressed are not dropped.
+	ioutil.WriteFile(filepath.Join(root, "remote/file1"), []byte("file1 local"), 0644)
+	expectState(vtree.State{Path: "remote/file1", Op: vtree.ModifiedOp})
+
+	// Only the local changes are pushed, the remote dir is watched.
+	time.Sleep(w.suppressWindow())
+	ioutil.WriteFile(filepath.Join(root, "remote/local"), []byte("local"), 0644)
+	expectState(vtree.State{Path: "remote/local", Op: vtree.AddedOp})
+	select {
+	case state := <-vt.StateChanges():
+		t.Errorf("Expected no other state, got: %+v", state)
+	case <-time.After(200 * time.Millisecond):
+	}
+}